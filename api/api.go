@@ -0,0 +1,651 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/config"
+	"github.com/0x10240/mihomo-speedtest/history"
+	"github.com/0x10240/mihomo-speedtest/result"
+	"github.com/0x10240/mihomo-speedtest/tester"
+)
+
+const (
+	KindDelay     = "delay"
+	KindBandwidth = "bandwidth"
+)
+
+// maxJobs 为保留的任务数
+const maxJobs = 100
+
+const (
+	StateQueued    = "queued"
+	StateRunning   = "running"
+	StateDone      = "done"
+	StateCancelled = "cancelled"
+)
+
+// Loader 加载节点，返回节点与每个节点来自的配置
+type Loader func() (map[string]config.CProxy, map[string]string)
+
+// Runner 运行一次测试并返回处理后的结果，opts.OnResult 在每个节点完成时调用
+type Runner func(ctx context.Context, kind string, names []string, proxies map[string]config.CProxy, sources map[string]string, opts tester.Options) []result.Result
+
+// Request 为启动测试任务的参数，未设置的参数使用命令行中的值，
+// SizeMB、Concurrent、DelayCount 不能超过命令行中的值，流量上限始终使用命令行中的值。
+// Names 与 Filter 同时为空时测试全部节点
+type Request struct {
+	Kind       string   `json:"kind"`
+	Filter     string   `json:"filter,omitempty"`
+	Names      []string `json:"names,omitempty"`
+	SizeMB     int      `json:"size_mb,omitempty"`
+	Timeout    string   `json:"timeout,omitempty"`
+	Concurrent int      `json:"concurrent,omitempty"`
+	DelayURL   string   `json:"delay_url,omitempty"`
+	DelayCount int      `json:"delay_count,omitempty"`
+}
+
+// Job 为一个测试任务，任务按提交顺序依次运行
+type Job struct {
+	ID       string          `json:"id"`
+	Request  Request         `json:"request"`
+	State    string          `json:"state"`
+	Created  time.Time       `json:"created"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished"`
+	Total    int             `json:"total"`
+	Done     int             `json:"done"`
+	Results  []result.Result `json:"results,omitempty"`
+
+	names   []string
+	opts    tester.Options
+	cancel  context.CancelFunc
+	changed chan struct{}
+}
+
+// Latest 为某类测试最近一次完成的结果
+type Latest struct {
+	Kind    string          `json:"kind"`
+	Time    time.Time       `json:"time"`
+	Results []result.Result `json:"results"`
+}
+
+// Node 为已加载的节点
+type Node struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`
+	Server string `json:"server"`
+	Source string `json:"source,omitempty"`
+	UDP    bool   `json:"udp"`
+}
+
+// Server 在内存中保存已加载的节点，通过 HTTP API 按需运行延迟与带宽测试
+type Server struct {
+	load Loader
+	run  Runner
+	opts tester.Options
+
+	mu      sync.Mutex
+	proxies map[string]config.CProxy
+	sources map[string]string
+	jobs    map[string]*Job
+	order   []string
+	latest  map[string]Latest
+	nextID  int
+	queue   chan *Job
+	history *history.Store
+
+	// delays、selected 为兼容 mihomo 接口的延迟记录与 GLOBAL 组当前选中的节点
+	delays   map[string][]DelayHistory
+	selected string
+	// testLock 为与定时任务、API 任务共用的测试锁，控制面板的延迟测试也需要取得它
+	testLock *sync.Mutex
+}
+
+// New 创建 Server，proxies、sources 为已加载的节点，load 用于重新加载，opts 为任务参数的默认值
+func New(proxies map[string]config.CProxy, sources map[string]string, load Loader, run Runner, opts tester.Options) *Server {
+	return &Server{
+		load:    load,
+		run:     run,
+		opts:    opts,
+		proxies: proxies,
+		sources: sources,
+		jobs:    make(map[string]*Job),
+		latest:  make(map[string]Latest),
+		queue:   make(chan *Job, 64),
+		delays:  make(map[string][]DelayHistory),
+	}
+}
+
+// Run 依次运行提交的任务，直到 ctx 被取消
+func (s *Server) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.queue:
+			s.runJob(ctx, job)
+		}
+	}
+}
+
+func (s *Server) runJob(ctx context.Context, job *Job) {
+	s.mu.Lock()
+	if job.State == StateCancelled {
+		s.mu.Unlock()
+		return
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	job.cancel = cancel
+	job.State = StateRunning
+	job.Started = time.Now()
+	proxies, sources := s.proxies, s.sources
+	s.notify(job)
+	s.mu.Unlock()
+
+	opts := job.opts
+	opts.OnResult = func(res result.Result) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		job.Done++
+		job.Results = append(job.Results, res)
+		s.notify(job)
+	}
+	results := s.run(jobCtx, job.Request.Kind, job.names, proxies, sources, opts)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.Results = results
+	job.Finished = time.Now()
+	if jobCtx.Err() != nil {
+		job.State = StateCancelled
+	} else {
+		job.State = StateDone
+	}
+	s.notify(job)
+}
+
+// SetLatest 记录某类测试最近一次完成的结果，包括 API 任务与定时任务。
+// partial 为 true 时只测试了部分节点，按名称合并到之前的结果中
+func (s *Server) SetLatest(kind string, start time.Time, results []result.Result, partial bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if partial {
+		results = result.MergeByName(s.latest[kind].Results, results)
+	}
+	s.latest[kind] = Latest{Kind: kind, Time: start, Results: results}
+	if kind == KindDelay {
+		s.recordDelays(start, results)
+	}
+}
+
+// SetTestLock 设置测试锁，控制面板的延迟测试在取得锁后进行，不与带宽测试同时运行
+func (s *Server) SetTestLock(lock *sync.Mutex) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.testLock = lock
+}
+
+// SetHistory 设置历史数据库，用于 /api/history 查询，并以其中最近的完整运行
+// 及之后只测试部分节点的运行作为各类测试的最新结果
+func (s *Server) SetHistory(store *history.Store) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.history = store
+	for _, kind := range []string{KindDelay, KindBandwidth} {
+		if _, ok := s.latest[kind]; ok {
+			continue
+		}
+		runs, err := store.Runs(time.Now().Add(-7*24*time.Hour), kind)
+		if err != nil {
+			return err
+		}
+		for _, run := range runs {
+			latest := Latest{Kind: kind, Time: run.Time, Results: run.Results}
+			if run.Partial {
+				latest.Results = result.MergeByName(s.latest[kind].Results, run.Results)
+			}
+			s.latest[kind] = latest
+		}
+	}
+	return nil
+}
+
+// notify 唤醒等待任务变化的事件流，调用时需持有 s.mu
+func (s *Server) notify(job *Job) {
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+// Register 在 mux 上注册 /api/ 下的接口
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/nodes", s.handleNodes)
+	mux.HandleFunc("/api/reload", s.handleReload)
+	mux.HandleFunc("/api/jobs", s.handleJobs)
+	mux.HandleFunc("/api/jobs/", s.handleJob)
+	mux.HandleFunc("/api/results", s.handleResults)
+	mux.HandleFunc("/api/history", s.handleHistory)
+}
+
+// Protect 要求与 mihomo 一样的 Authorization: Bearer <secret> 或 token 参数。
+// 设置了 secret 时允许跨域访问，使其他地址上的控制面板可以使用；
+// secret 为空时拒绝来自其他网页的请求，避免任意网页通过浏览器提交任务或重新加载配置，
+// 并要求 Host 为本机地址，避免网页通过 DNS 重绑定把自己的域名指向本机后以同源身份访问
+func Protect(secret string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if secret == "" {
+			if !loopbackHost(r.Host) || !sameOrigin(r) {
+				writeJSON(w, http.StatusForbidden, map[string]string{"message": "Requests from other origins or hosts require -secret"})
+				return
+			}
+			handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// loopbackHost 判断 Host 是否为 localhost 或本机 IP
+func loopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// sameOrigin 判断请求是否来自同一地址的网页或非浏览器客户端，浏览器的跨域请求带有其他地址的 Origin
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+func (s *Server) handleNodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s.mu.Lock()
+	nodes := make([]Node, 0, len(s.proxies))
+	for name, proxy := range s.proxies {
+		nodes = append(nodes, Node{
+			Name:   name,
+			Type:   proxy.Type().String(),
+			Server: proxy.Addr(),
+			Source: s.sources[name],
+			UDP:    proxy.SupportUDP(),
+		})
+	}
+	s.mu.Unlock()
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	writeJSON(w, http.StatusOK, nodes)
+}
+
+// handleReload 重新加载配置，正在运行的任务继续使用原来的节点
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	proxies, sources := s.load()
+	if len(proxies) == 0 {
+		writeError(w, http.StatusBadGateway, "no proxies found, keeping the loaded nodes")
+		return
+	}
+	s.mu.Lock()
+	s.proxies, s.sources = proxies, sources
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]int{"nodes": len(proxies)})
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		jobs := make([]Job, 0, len(s.order))
+		for _, id := range s.order {
+			job := *s.jobs[id]
+			job.Results = nil
+			jobs = append(jobs, job)
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request: %v", err)
+			return
+		}
+		job, err := s.Submit(req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		w.Header().Set("Location", "/api/jobs/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// Submit 校验参数并将任务加入队列，返回任务提交时的状态
+func (s *Server) Submit(req Request) (Job, error) {
+	if req.Kind != KindDelay && req.Kind != KindBandwidth {
+		return Job{}, fmt.Errorf("kind must be %q or %q", KindDelay, KindBandwidth)
+	}
+	opts := s.opts
+	if err := checkLimit("size_mb", req.SizeMB, opts.SizeMB); err != nil {
+		return Job{}, err
+	}
+	if err := checkLimit("concurrent", req.Concurrent, opts.Concurrent); err != nil {
+		return Job{}, err
+	}
+	if err := checkLimit("delay_count", req.DelayCount, opts.DelayCount); err != nil {
+		return Job{}, err
+	}
+	if req.SizeMB > 0 {
+		opts.SizeMB = req.SizeMB
+	}
+	if req.Timeout != "" {
+		timeout, err := time.ParseDuration(req.Timeout)
+		if err != nil || timeout <= 0 {
+			return Job{}, fmt.Errorf("invalid timeout %q", req.Timeout)
+		}
+		opts.Timeout = timeout
+	}
+	if req.Concurrent > 0 {
+		opts.Concurrent = req.Concurrent
+	}
+	if req.DelayURL != "" {
+		opts.DelayTestUrl = req.DelayURL
+	}
+	if req.DelayCount > 0 {
+		opts.DelayCount = req.DelayCount
+	}
+	var filter *regexp.Regexp
+	if req.Filter != "" {
+		var err error
+		if filter, err = regexp.Compile(req.Filter); err != nil {
+			return Job{}, fmt.Errorf("invalid filter: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names, err := s.selectNames(req.Names, filter)
+	if err != nil {
+		return Job{}, err
+	}
+	s.nextID++
+	job := &Job{
+		ID:      strconv.Itoa(s.nextID),
+		Request: req,
+		State:   StateQueued,
+		Created: time.Now(),
+		Total:   len(names),
+		Results: make([]result.Result, 0, len(names)),
+		names:   names,
+		opts:    opts,
+		changed: make(chan struct{}),
+	}
+	select {
+	case s.queue <- job:
+	default:
+		s.nextID--
+		return Job{}, fmt.Errorf("too many queued jobs")
+	}
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	s.pruneJobs()
+	return *job, nil
+}
+
+// checkLimit 拒绝超过命令行参数的请求，使 API 无法发起比命令行配置更多流量的测试
+func checkLimit(name string, v, limit int) error {
+	if v > limit {
+		return fmt.Errorf("%s must not exceed %d", name, limit)
+	}
+	return nil
+}
+
+// pruneJobs 只保留最近 maxJobs 个任务，未结束的任务不删除，调用时需持有 s.mu
+func (s *Server) pruneJobs() {
+	kept := s.order[:0]
+	excess := len(s.order) - maxJobs
+	for _, id := range s.order {
+		state := s.jobs[id].State
+		if excess > 0 && (state == StateDone || state == StateCancelled) {
+			delete(s.jobs, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	s.order = kept
+}
+
+// selectNames 返回按名称排序的待测节点，调用时需持有 s.mu
+func (s *Server) selectNames(names []string, filter *regexp.Regexp) ([]string, error) {
+	selected := make([]string, 0)
+	if len(names) > 0 {
+		for _, name := range names {
+			if _, ok := s.proxies[name]; !ok {
+				return nil, fmt.Errorf("unknown node %q", name)
+			}
+			if filter == nil || filter.MatchString(name) {
+				selected = append(selected, name)
+			}
+		}
+	} else {
+		for name := range s.proxies {
+			if filter == nil || filter.MatchString(name) {
+				selected = append(selected, name)
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no matching nodes")
+	}
+	sort.Strings(selected)
+	return selected, nil
+}
+
+// handleJob 处理 /api/jobs/{id} 与 /api/jobs/{id}/events
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	s.mu.Lock()
+	job, ok := s.jobs[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "job %s not found", id)
+		return
+	}
+
+	switch {
+	case sub == "events" && r.Method == http.MethodGet:
+		s.streamEvents(w, r, job)
+	case sub == "" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		snapshot := *job
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, snapshot)
+	case sub == "" && r.Method == http.MethodDelete:
+		s.mu.Lock()
+		switch job.State {
+		case StateQueued:
+			job.State = StateCancelled
+			job.Finished = time.Now()
+			s.notify(job)
+		case StateRunning:
+			job.cancel()
+		}
+		snapshot := *job
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, snapshot)
+	case sub == "" || sub == "events":
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// streamEvents 以 Server-Sent Events 推送任务进度：每完成一个节点发送一个 result 事件，
+// 任务状态变化时发送 state 事件，任务结束后发送包含最终结果的 done 事件并关闭连接
+func (s *Server) streamEvents(w http.ResponseWriter, r *http.Request, job *Job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	sent, state := 0, ""
+	for {
+		s.mu.Lock()
+		// 任务结束时 Results 被替换为处理后的结果，不再逐个推送
+		finished := job.State == StateDone || job.State == StateCancelled
+		var pending []result.Result
+		if !finished && sent < len(job.Results) {
+			pending = append(pending, job.Results[sent:]...)
+			sent = len(job.Results)
+		}
+		changedState := job.State != state
+		state = job.State
+		snapshot := *job
+		changed := job.changed
+		s.mu.Unlock()
+
+		for _, res := range pending {
+			writeEvent(w, "result", res)
+		}
+		if finished {
+			writeEvent(w, "done", snapshot)
+			flusher.Flush()
+			return
+		}
+		if changedState {
+			snapshot.Results = nil
+			writeEvent(w, "state", snapshot)
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-changed:
+		}
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event string, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+}
+
+// handleResults 返回指定类型最近一次完成的结果，kind 默认为 bandwidth
+func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	if kind == "" {
+		kind = KindBandwidth
+	}
+	s.mu.Lock()
+	latest, ok := s.latest[kind]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no finished %s test", kind)
+		return
+	}
+	writeJSON(w, http.StatusOK, latest)
+}
+
+// handleHistory 查询历史数据库：指定 node 时返回该节点的变化趋势，否则返回各节点的可靠性。
+// since 为查询的时间范围，默认 7 天；kind 为 delay 或 bandwidth，默认两者
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s.mu.Lock()
+	store := s.history
+	s.mu.Unlock()
+	if store == nil {
+		writeError(w, http.StatusNotFound, "history is not available")
+		return
+	}
+
+	query := r.URL.Query()
+	since := 7 * 24 * time.Hour
+	if v := query.Get("since"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid since %q", v)
+			return
+		}
+		since = d
+	}
+	kind := query.Get("kind")
+	if kind != "" && kind != KindDelay && kind != KindBandwidth {
+		writeError(w, http.StatusBadRequest, "kind must be %q or %q", KindDelay, KindBandwidth)
+		return
+	}
+	runs, err := store.Runs(time.Now().Add(-since), kind)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read history: %v", err)
+		return
+	}
+	if node := query.Get("node"); node != "" {
+		writeJSON(w, http.StatusOK, history.TrendOf(runs, node))
+		return
+	}
+	writeJSON(w, http.StatusOK, history.ReliabilityOf(runs))
+}
@@ -0,0 +1,350 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/config"
+	"github.com/0x10240/mihomo-speedtest/history"
+	"github.com/0x10240/mihomo-speedtest/result"
+	"github.com/0x10240/mihomo-speedtest/tester"
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/adapter/outbound"
+)
+
+func TestServer(t *testing.T) {
+	proxies := map[string]config.CProxy{
+		"HK 01": adapter.NewProxy(outbound.NewDirect()),
+		"JP 01": adapter.NewProxy(outbound.NewDirect()),
+	}
+	sources := map[string]string{"HK 01": "sub.example.com", "JP 01": "sub.example.com"}
+	// 每次 release 完成一个节点，最后一次 release 结束任务
+	release := make(chan struct{})
+	var s *Server
+	var jobOpts tester.Options
+	jobsRun := 0
+	run := func(ctx context.Context, kind string, names []string, proxies map[string]config.CProxy, sources map[string]string, opts tester.Options) []result.Result {
+		jobOpts = opts
+		jobsRun++
+		results := make([]result.Result, 0, len(names))
+		for _, name := range names {
+			<-release
+			res := result.Result{Name: name, Delay: uint16(100 * jobsRun)}
+			opts.OnResult(res)
+			results = append(results, res)
+		}
+		<-release
+		s.SetLatest(kind, time.Now(), results, len(names) < len(proxies))
+		return results
+	}
+	load := func() (map[string]config.CProxy, map[string]string) { return proxies, sources }
+	s = New(proxies, sources, load, run, tester.Options{Timeout: time.Second, SizeMB: 100, Concurrent: 4, DelayCount: 3, MaxTraffic: 1 << 30})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+	mux := http.NewServeMux()
+	s.Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, _ := http.Get(ts.URL + "/api/results?kind=delay")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("results before any job: status %d", resp.StatusCode)
+	}
+	resp, _ = http.Post(ts.URL+"/api/jobs", "application/json", strings.NewReader(`{"kind":"delay","names":["nope"]}`))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown node: status %d", resp.StatusCode)
+	}
+
+	// 超过命令行参数的请求被拒绝
+	for _, body := range []string{`{"kind":"bandwidth","size_mb":1000}`, `{"kind":"bandwidth","concurrent":64}`, `{"kind":"delay","delay_count":100}`} {
+		resp, _ = http.Post(ts.URL+"/api/jobs", "application/json", strings.NewReader(body))
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d", body, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Post(ts.URL+"/api/jobs", "application/json", strings.NewReader(`{"kind":"delay","filter":"01","timeout":"2s","delay_count":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var job Job
+	json.NewDecoder(resp.Body).Decode(&job)
+	if resp.StatusCode != http.StatusAccepted || job.ID == "" || job.Total != 2 {
+		t.Fatalf("create job: status %d, %+v", resp.StatusCode, job)
+	}
+
+	events, err := http.Get(ts.URL + "/api/jobs/" + job.ID + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer events.Body.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(events.Body)
+	// next 读取事件直到收到 event 类型的事件
+	next := func(event string) {
+		for scanner.Scan() {
+			if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+				names = append(names, name)
+				if name == event {
+					return
+				}
+			}
+		}
+	}
+	release <- struct{}{}
+	next("result")
+	release <- struct{}{}
+	next("result")
+	release <- struct{}{}
+	next("done")
+	got := strings.Join(names, ",")
+	if !strings.HasSuffix(got, "done") || strings.Count(got, "result") != 2 {
+		t.Errorf("events = %s", got)
+	}
+	// 未设置的参数与流量上限沿用命令行的值
+	if jobOpts.Timeout != 2*time.Second || jobOpts.DelayCount != 2 || jobOpts.SizeMB != 100 || jobOpts.MaxTraffic != 1<<30 {
+		t.Errorf("job options: %+v", jobOpts)
+	}
+
+	resp, _ = http.Get(ts.URL + "/api/jobs/" + job.ID)
+	json.NewDecoder(resp.Body).Decode(&job)
+	if job.State != StateDone || job.Done != 2 || len(job.Results) != 2 {
+		t.Errorf("job after completion: %+v", job)
+	}
+
+	var latest Latest
+	resp, _ = http.Get(ts.URL + "/api/results?kind=delay")
+	json.NewDecoder(resp.Body).Decode(&latest)
+	if len(latest.Results) != 2 || latest.Results[0].Name != "HK 01" {
+		t.Errorf("latest results: %+v", latest)
+	}
+
+	// 只测试部分节点的任务合并到最新结果中，其他节点仍然保留
+	resp, _ = http.Post(ts.URL+"/api/jobs", "application/json", strings.NewReader(`{"kind":"delay","names":["JP 01"]}`))
+	json.NewDecoder(resp.Body).Decode(&job)
+	release <- struct{}{}
+	release <- struct{}{}
+	for job.State != StateDone {
+		time.Sleep(10 * time.Millisecond)
+		resp, _ = http.Get(ts.URL + "/api/jobs/" + job.ID)
+		json.NewDecoder(resp.Body).Decode(&job)
+	}
+	latest = Latest{}
+	resp, _ = http.Get(ts.URL + "/api/results?kind=delay")
+	json.NewDecoder(resp.Body).Decode(&latest)
+	delays := make(map[string]uint16)
+	for _, res := range latest.Results {
+		delays[res.Name] = res.Delay
+	}
+	if len(latest.Results) != 2 || delays["HK 01"] != 100 || delays["JP 01"] != 200 {
+		t.Errorf("latest after a subset job: %+v", latest.Results)
+	}
+}
+
+func TestController(t *testing.T) {
+	proxies := map[string]config.CProxy{"HK 01": adapter.NewProxy(outbound.NewDirect())}
+	load := func() (map[string]config.CProxy, map[string]string) { return proxies, nil }
+	s := New(proxies, nil, load, nil, tester.Options{Timeout: time.Second})
+	s.SetLatest(KindDelay, time.Now(), []result.Result{{Name: "HK 01", Delay: 9999}}, false)
+	mux := http.NewServeMux()
+	s.RegisterController(mux, "test")
+	ts := httptest.NewServer(Protect("secret", mux))
+	defer ts.Close()
+
+	get := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp, _ := http.Get(ts.URL + "/proxies")
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without secret: status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Proxies map[string]controllerProxy `json:"proxies"`
+	}
+	json.NewDecoder(get("/proxies").Body).Decode(&body)
+	if body.Proxies[globalGroup].Now != "HK 01" || len(body.Proxies["HK 01"].History) != 1 || body.Proxies["HK 01"].Alive {
+		t.Errorf("proxies: %+v", body.Proxies)
+	}
+
+	if resp := get("/proxies/JP%2001/delay?timeout=1000"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown node: status %d", resp.StatusCode)
+	}
+	if resp := get("/proxies/HK%2001/delay?timeout=abc"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid timeout: status %d", resp.StatusCode)
+	}
+
+	// 其他测试运行时立即返回 503，不等待
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer target.Close()
+	delayPath := "/proxies/HK%2001/delay?timeout=1000&url=" + url.QueryEscape(target.URL)
+	var testMu sync.Mutex
+	s.SetTestLock(&testMu)
+	testMu.Lock()
+	if resp := get(delayPath); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("delay while another test runs: status %d", resp.StatusCode)
+	}
+	testMu.Unlock()
+	if resp := get(delayPath); resp.StatusCode != http.StatusOK {
+		t.Errorf("delay after unlock: status %d", resp.StatusCode)
+	}
+}
+
+func TestControllerGroupDelay(t *testing.T) {
+	// 超过并发数的无响应节点也都要测试到，timeout 作用于每个节点而不是整批
+	proxies := make(map[string]config.CProxy)
+	for i := 0; i < 60; i++ {
+		proxies[fmt.Sprintf("node %02d", i)] = adapter.NewProxy(outbound.NewDirect())
+	}
+	s := New(proxies, nil, nil, nil, tester.Options{})
+	mux := http.NewServeMux()
+	s.RegisterController(mux, "test")
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	hang := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer hang.Close()
+
+	resp, err := http.Get(ts.URL + "/group/GLOBAL/delay?timeout=500&url=" + url.QueryEscape(hang.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name := range proxies {
+		if len(s.delays[name]) != 1 {
+			t.Errorf("%s: delay history %+v", name, s.delays[name])
+		}
+	}
+}
+
+func TestProtect(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	request := func(h http.Handler, method, origin, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "http://127.0.0.1:9090/api/jobs", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 没有密钥时只接受同一地址的网页与非浏览器客户端，不开放跨域
+	open := Protect("", ok)
+	if rec := request(open, http.MethodPost, "https://evil.example.com", ""); rec.Code != http.StatusForbidden || rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("cross-origin without secret: %d %v", rec.Code, rec.Header())
+	}
+	if rec := request(open, http.MethodPost, "http://127.0.0.1:9090", ""); rec.Code != http.StatusOK {
+		t.Errorf("same origin without secret: %d", rec.Code)
+	}
+	if rec := request(open, http.MethodPost, "", ""); rec.Code != http.StatusOK {
+		t.Errorf("no origin without secret: %d", rec.Code)
+	}
+	// DNS 重绑定：网页的域名指向本机，Origin 与 Host 相同但不是本机地址
+	for _, host := range []string{"rebind.example.com:9090", "rebind.example.com"} {
+		req := httptest.NewRequest(http.MethodPost, "http://"+host+"/api/jobs", nil)
+		req.Header.Set("Origin", "http://"+host)
+		rec := httptest.NewRecorder()
+		open.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("host %s without secret: %d", host, rec.Code)
+		}
+	}
+	for _, host := range []string{"localhost:9090", "[::1]:9090", "127.0.0.1"} {
+		req := httptest.NewRequest(http.MethodGet, "http://"+host+"/api/jobs", nil)
+		rec := httptest.NewRecorder()
+		open.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("host %s without secret: %d", host, rec.Code)
+		}
+	}
+
+	protected := Protect("secret", ok)
+	if rec := request(protected, http.MethodOptions, "https://dashboard.example.com", ""); rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("preflight with secret: %d %v", rec.Code, rec.Header())
+	}
+	if rec := request(protected, http.MethodPost, "https://dashboard.example.com", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong secret: %d", rec.Code)
+	}
+	if rec := request(protected, http.MethodPost, "https://dashboard.example.com", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("cross-origin with secret: %d", rec.Code)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	now := time.Now()
+	store.Add(history.Run{Time: now.Add(-2 * time.Hour), Kind: KindBandwidth, Results: []result.Result{{Name: "HK 01", Bandwidth: 1 << 20}, {Name: "JP 01"}}})
+	store.Add(history.Run{Time: now.Add(-time.Hour), Kind: KindBandwidth, Results: []result.Result{{Name: "HK 01", Bandwidth: 2 << 20}, {Name: "JP 01", Bandwidth: 1 << 20}}})
+	// 之后只重新测试了 HK 01
+	store.Add(history.Run{Time: now.Add(-30 * time.Minute), Kind: KindBandwidth, Results: []result.Result{{Name: "HK 01", Bandwidth: 3 << 20}}, Partial: true})
+
+	s := New(map[string]config.CProxy{}, nil, nil, nil, tester.Options{})
+	if err := s.SetHistory(store); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	s.Register(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	var latest Latest
+	resp, _ := http.Get(ts.URL + "/api/results")
+	json.NewDecoder(resp.Body).Decode(&latest)
+	if len(latest.Results) != 2 || latest.Results[0].Bandwidth != 3<<20 || latest.Results[1].Bandwidth != 1<<20 {
+		t.Errorf("latest from history: %+v", latest)
+	}
+
+	var reliability []history.Reliability
+	resp, _ = http.Get(ts.URL + "/api/history?since=24h")
+	json.NewDecoder(resp.Body).Decode(&reliability)
+	if len(reliability) != 2 || reliability[0].Name != "HK 01" || reliability[1].Successes != 1 {
+		t.Errorf("reliability: %+v", reliability)
+	}
+
+	var trend history.Trend
+	resp, _ = http.Get(ts.URL + "/api/history?node=HK%2001&kind=bandwidth")
+	json.NewDecoder(resp.Body).Decode(&trend)
+	if len(trend.Points) != 3 || trend.BandwidthPerDay <= 0 {
+		t.Errorf("trend: %+v", trend)
+	}
+
+	resp, _ = http.Get(ts.URL + "/api/history?since=forever")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid since: status %d", resp.StatusCode)
+	}
+}
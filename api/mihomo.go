@@ -0,0 +1,290 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/config"
+	"github.com/0x10240/mihomo-speedtest/result"
+	"github.com/0x10240/mihomo-speedtest/tester"
+)
+
+// globalGroup 为兼容接口中包含全部节点的策略组，控制面板按策略组显示节点
+const globalGroup = "GLOBAL"
+
+// maxDelayHistory 为每个节点保留的延迟记录数，与 mihomo 一致
+const maxDelayHistory = 10
+
+// DelayHistory 为一次延迟测试的记录，格式与 mihomo 相同，失败时 Delay 为 0
+type DelayHistory struct {
+	Time  time.Time `json:"time"`
+	Delay uint16    `json:"delay"`
+}
+
+// controllerProxy 为 mihomo GET /proxies 返回的节点格式
+type controllerProxy struct {
+	Name    string         `json:"name"`
+	Type    string         `json:"type"`
+	UDP     bool           `json:"udp"`
+	XUDP    bool           `json:"xudp"`
+	TFO     bool           `json:"tfo"`
+	Alive   bool           `json:"alive"`
+	History []DelayHistory `json:"history"`
+	Extra   map[string]any `json:"extra"`
+	Now     string         `json:"now,omitempty"`
+	All     []string       `json:"all,omitempty"`
+}
+
+// RegisterController 在 mux 上注册与 mihomo external-controller 兼容的接口子集，
+// 可以将 metacubexd、yacd 等控制面板指向本服务浏览节点并测试延迟
+func (s *Server) RegisterController(mux *http.ServeMux, version string) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			writeMessage(w, http.StatusNotFound, "Resource not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"hello": "mihomo"})
+	})
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"meta": true, "version": "mihomo-speedtest " + version})
+	})
+	mux.HandleFunc("/configs", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			// 不支持修改配置，接受请求以免控制面板报错
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"port": 0, "socks-port": 0, "mixed-port": 0, "mode": "global", "log-level": "info", "allow-lan": false})
+	})
+	mux.HandleFunc("/providers/proxies", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"providers": map[string]any{}})
+	})
+	mux.HandleFunc("/proxies", s.handleControllerProxies)
+	mux.HandleFunc("/proxies/", s.handleControllerProxy)
+	mux.HandleFunc("/group/", s.handleControllerGroup)
+}
+
+func writeMessage(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+// recordDelays 记录延迟测试结果，调用时需持有 s.mu
+func (s *Server) recordDelays(at time.Time, results []result.Result) {
+	for _, res := range results {
+		if res.Skipped {
+			continue
+		}
+		delay := res.Delay
+		if delay == 9999 {
+			delay = 0
+		}
+		history := append(s.delays[res.Name], DelayHistory{Time: at, Delay: delay})
+		if len(history) > maxDelayHistory {
+			history = history[len(history)-maxDelayHistory:]
+		}
+		s.delays[res.Name] = history
+	}
+}
+
+// controllerProxies 返回全部节点与 GLOBAL 策略组，调用时需持有 s.mu
+func (s *Server) controllerProxies() map[string]controllerProxy {
+	names := make([]string, 0, len(s.proxies))
+	for name := range s.proxies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	proxies := make(map[string]controllerProxy, len(names)+1)
+	for _, name := range names {
+		proxies[name] = s.controllerProxy(name)
+	}
+	now := s.selected
+	if _, ok := s.proxies[now]; !ok && len(names) > 0 {
+		now = names[0]
+	}
+	proxies[globalGroup] = controllerProxy{
+		Name:    globalGroup,
+		Type:    "Selector",
+		UDP:     true,
+		Alive:   true,
+		History: []DelayHistory{},
+		Extra:   map[string]any{},
+		Now:     now,
+		All:     names,
+	}
+	return proxies
+}
+
+// controllerProxy 返回单个节点，调用时需持有 s.mu
+func (s *Server) controllerProxy(name string) controllerProxy {
+	proxy := s.proxies[name]
+	history := s.delays[name]
+	if history == nil {
+		history = []DelayHistory{}
+	}
+	// 与 mihomo 一致，未测试过的节点视为可用
+	alive := len(history) == 0 || history[len(history)-1].Delay > 0
+	return controllerProxy{
+		Name:    name,
+		Type:    proxy.Type().String(),
+		UDP:     proxy.SupportUDP(),
+		XUDP:    proxy.SupportXUDP(),
+		TFO:     proxy.SupportTFO(),
+		Alive:   alive,
+		History: history,
+		Extra:   map[string]any{},
+	}
+}
+
+func (s *Server) handleControllerProxies(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	proxies := s.controllerProxies()
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{"proxies": proxies})
+}
+
+// handleControllerProxy 处理 /proxies/{name} 与 /proxies/{name}/delay，名称需要 URL 编码
+func (s *Server) handleControllerProxy(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), "/proxies/")
+	escaped, sub, _ := strings.Cut(path, "/")
+	name, err := url.PathUnescape(escaped)
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, "Body invalid")
+		return
+	}
+
+	s.mu.Lock()
+	_, isProxy := s.proxies[name]
+	s.mu.Unlock()
+	if !isProxy && name != globalGroup {
+		writeMessage(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+
+	switch {
+	case sub == "" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		proxy := s.controllerProxies()[name]
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, proxy)
+	case sub == "" && r.Method == http.MethodPut && name == globalGroup:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeMessage(w, http.StatusBadRequest, "Body invalid")
+			return
+		}
+		s.mu.Lock()
+		_, ok := s.proxies[req.Name]
+		if ok {
+			s.selected = req.Name
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeMessage(w, http.StatusBadRequest, "Selector update error: proxy not exist")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case sub == "delay" && r.Method == http.MethodGet && isProxy:
+		delays, status, message := s.controllerDelay(r, []string{name})
+		if status != http.StatusOK {
+			writeMessage(w, status, message)
+			return
+		}
+		if delays[name] == 0 {
+			writeMessage(w, http.StatusServiceUnavailable, "An error occurred in the delay test")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]uint16{"delay": delays[name]})
+	default:
+		writeMessage(w, http.StatusNotFound, "Resource not found")
+	}
+}
+
+// handleControllerGroup 处理 /group/GLOBAL/delay，并发测试全部节点，只返回测试成功的节点
+func (s *Server) handleControllerGroup(w http.ResponseWriter, r *http.Request) {
+	name, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/group/"), "/")
+	if name != globalGroup || sub != "delay" || r.Method != http.MethodGet {
+		writeMessage(w, http.StatusNotFound, "Resource not found")
+		return
+	}
+	s.mu.Lock()
+	names := make([]string, 0, len(s.proxies))
+	for name := range s.proxies {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+
+	delays, status, message := s.controllerDelay(r, names)
+	if status != http.StatusOK {
+		writeMessage(w, status, message)
+		return
+	}
+	for name, delay := range delays {
+		if delay == 0 {
+			delete(delays, name)
+		}
+	}
+	writeJSON(w, http.StatusOK, delays)
+}
+
+// controllerDelay 按 url、timeout（毫秒）参数测试节点延迟，返回各节点的延迟，失败为 0。
+// timeout 作用于每个节点，整批测试随请求结束；其他测试正在运行时返回 503，不等待带宽测试结束
+func (s *Server) controllerDelay(r *http.Request, names []string) (map[string]uint16, int, string) {
+	query := r.URL.Query()
+	timeout, err := strconv.ParseInt(query.Get("timeout"), 10, 32)
+	if err != nil || timeout <= 0 {
+		return nil, http.StatusBadRequest, "Body invalid"
+	}
+	// 控制面板频繁测试延迟，不查询出口信息或测试 UDP
+	opts := tester.Options{
+		Timeout:      time.Duration(timeout) * time.Millisecond,
+		DelayTestUrl: s.opts.DelayTestUrl,
+		DelayCount:   1,
+	}
+	if u := query.Get("url"); u != "" {
+		opts.DelayTestUrl = u
+	}
+
+	s.mu.Lock()
+	selected := make(map[string]config.CProxy, len(names))
+	for _, name := range names {
+		if proxy, ok := s.proxies[name]; ok {
+			selected[name] = proxy
+		}
+	}
+	lock := s.testLock
+	s.mu.Unlock()
+
+	if lock != nil {
+		if !lock.TryLock() {
+			return nil, http.StatusServiceUnavailable, "Another test is running"
+		}
+		defer lock.Unlock()
+	}
+	start := time.Now()
+	results := tester.TestProxiesDelay(r.Context(), selected, opts)
+	if r.Context().Err() != nil {
+		return nil, http.StatusGatewayTimeout, "Timeout"
+	}
+
+	s.mu.Lock()
+	s.recordDelays(start, results)
+	s.mu.Unlock()
+
+	delays := make(map[string]uint16, len(results))
+	for _, res := range results {
+		if res.Delay != 9999 && !res.Skipped {
+			delays[res.Name] = res.Delay
+		} else {
+			delays[res.Name] = 0
+		}
+	}
+	return delays, http.StatusOK, ""
+}
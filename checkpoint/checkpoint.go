@@ -0,0 +1,89 @@
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+// Load 读取检查点文件中已完成的结果，文件不存在时返回空结果。
+// 检查点为每行一个 JSON 的结果，中断时写了一半的最后一行会被忽略。
+func Load(path string) ([]result.Result, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	results := make([]result.Result, 0)
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var res result.Result
+		if err := json.Unmarshal(scanner.Bytes(), &res); err != nil {
+			continue
+		}
+		// 同一节点出现多次时以最后一次为准
+		if i, ok := index[res.Name]; ok {
+			results[i] = res
+			continue
+		}
+		index[res.Name] = len(results)
+		results = append(results, res)
+	}
+	return results, scanner.Err()
+}
+
+// Writer 将完成的结果逐行追加到检查点文件，可并发调用
+type Writer struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Create 打开检查点文件，resume 为 false 时清空已有内容
+func Create(path string, resume bool) (*Writer, error) {
+	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	// 上次中断时最后一行可能没有写完，另起一行继续追加
+	if resume {
+		if info, err := file.Stat(); err == nil && info.Size() > 0 {
+			last := make([]byte, 1)
+			if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+				file.Write([]byte{'\n'})
+			}
+		}
+	}
+	return &Writer{file: file}, nil
+}
+
+// Append 写入一个完成的结果
+func (w *Writer) Append(res result.Result) error {
+	data, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err = w.file.Write(append(data, '\n'))
+	return err
+}
+
+func (w *Writer) Close() error {
+	return w.file.Close()
+}
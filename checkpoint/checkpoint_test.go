@@ -0,0 +1,41 @@
+package checkpoint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+func TestResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "progress.jsonl")
+
+	w, err := Create(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append(result.Result{Name: "a", Bandwidth: 1})
+	w.Append(result.Result{Name: "b", Bandwidth: 2})
+	w.Close()
+
+	// 模拟中断时写了一半的最后一行
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	f.WriteString(`{"name":"c","band`)
+	f.Close()
+
+	w, err = Create(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append(result.Result{Name: "a", Bandwidth: 3})
+	w.Close()
+
+	results, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Name != "a" || results[0].Bandwidth != 3 || results[1].Name != "b" {
+		t.Errorf("Load() = %+v", results)
+	}
+}
@@ -23,7 +23,15 @@ type RawConfig struct {
 }
 
 func LoadAllProxies(configPaths string, proxy string, forwardProxy string) map[string]CProxy {
+	allProxies, _ := LoadAllProxiesWithSources(configPaths, proxy, forwardProxy)
+	return allProxies
+}
+
+// LoadAllProxiesWithSources 同 LoadAllProxies，另外返回每个节点来自的配置，
+// 订阅地址只保留域名，避免泄露其中的令牌
+func LoadAllProxiesWithSources(configPaths string, proxy string, forwardProxy string) (map[string]CProxy, map[string]string) {
 	allProxies := make(map[string]CProxy)
+	sources := make(map[string]string)
 
 	for _, configPath := range strings.Split(configPaths, ",") {
 		body, err := readConfig(configPath, proxy)
@@ -41,11 +49,21 @@ func LoadAllProxies(configPaths string, proxy string, forwardProxy string) map[s
 		for name, proxy := range proxies {
 			if _, exists := allProxies[name]; !exists {
 				allProxies[name] = proxy
+				sources[name] = sourceName(configPath)
 			}
 		}
 	}
 
-	return allProxies
+	return allProxies, sources
+}
+
+func sourceName(configPath string) string {
+	if strings.HasPrefix(configPath, "http") {
+		if u, err := url.Parse(configPath); err == nil {
+			return u.Host
+		}
+	}
+	return configPath
 }
 
 func readConfig(configPath string, proxy string) ([]byte, error) {
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/api"
+	"github.com/0x10240/mihomo-speedtest/config"
+	"github.com/0x10240/mihomo-speedtest/filter"
+	"github.com/0x10240/mihomo-speedtest/history"
+	"github.com/0x10240/mihomo-speedtest/metrics"
+	"github.com/0x10240/mihomo-speedtest/nodename"
+	"github.com/0x10240/mihomo-speedtest/result"
+	"github.com/0x10240/mihomo-speedtest/schedule"
+	"github.com/0x10240/mihomo-speedtest/tester"
+)
+
+// daemonJob 为守护模式下按 cron 表达式定时运行的一类测试
+type daemonJob struct {
+	kind     string
+	schedule *schedule.Schedule
+	next     time.Time
+}
+
+func parseJobs(delaySpec, bandwidthSpec string) ([]*daemonJob, error) {
+	specs := []struct{ kind, spec string }{
+		{history.KindDelay, delaySpec},
+		{history.KindBandwidth, bandwidthSpec},
+	}
+	jobs := make([]*daemonJob, 0, len(specs))
+	now := time.Now()
+	for _, s := range specs {
+		if s.spec == "" {
+			continue
+		}
+		sched, err := schedule.Parse(s.spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s schedule: %w", s.kind, err)
+		}
+		next := sched.Next(now)
+		if next.IsZero() {
+			return nil, fmt.Errorf("%s schedule %q never runs", s.kind, s.spec)
+		}
+		jobs = append(jobs, &daemonJob{kind: s.kind, schedule: sched, next: next})
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("at least one of -delay-cron and -bandwidth-cron is required")
+	}
+	return jobs, nil
+}
+
+// runDaemon 按计划反复运行延迟与带宽测试，每次运行前重新加载配置，结果交给 rec 保存，直到 ctx 被取消
+func runDaemon(ctx context.Context, jobs []*daemonJob, rec *recorder, opts tester.Options, weights result.ScoreWeights, patterns []*regexp.Regexp) {
+	for _, job := range jobs {
+		fmt.Printf("Scheduled %s test, next run at %s\n", job.kind, job.next.Format(time.RFC3339))
+	}
+
+	for {
+		job := jobs[0]
+		for _, j := range jobs[1:] {
+			if j.next.Before(job.next) {
+				job = j
+			}
+		}
+
+		timer := time.NewTimer(time.Until(job.next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		fmt.Printf("\n[%s] Running %s test\n", time.Now().Format(time.RFC3339), job.kind)
+		results, start := runJob(ctx, job.kind, opts, weights, patterns)
+		// 中断的运行结果不完整，不写入历史
+		if ctx.Err() != nil {
+			return
+		}
+
+		if results != nil {
+			rec.record(job.kind, start, results, false)
+			working := 0
+			for _, res := range results {
+				if (job.kind == history.KindDelay && res.Delay > 0 && res.Delay != 9999) || (job.kind == history.KindBandwidth && res.Bandwidth > 0) {
+					working++
+				}
+			}
+			fmt.Printf("Finished %s test of %d nodes in %s, %d working\n", job.kind, len(results), time.Since(start).Round(time.Second), working)
+		}
+
+		// 运行时间超过间隔时跳过错过的运行
+		job.next = job.schedule.Next(time.Now())
+		if job.next.IsZero() {
+			fmt.Fprintf(os.Stderr, "The %s schedule has no further runs\n", job.kind)
+			return
+		}
+		fmt.Printf("Next %s test at %s\n", job.kind, job.next.Format(time.RFC3339))
+	}
+}
+
+// runJob 重新加载配置并运行一次定时测试，返回结果与测试开始的时间，配置无法加载时返回 nil
+func runJob(ctx context.Context, kind string, opts tester.Options, weights result.ScoreWeights, patterns []*regexp.Regexp) ([]result.Result, time.Time) {
+	allProxies, sources := config.LoadAllProxiesWithSources(*configPathConfig, *proxy, *forwardProxy)
+	if len(allProxies) == 0 {
+		fmt.Fprintln(os.Stderr, "No proxies found, skipping this run")
+		return nil, time.Time{}
+	}
+
+	// 与单次运行一致，延迟测试不按 -f 过滤
+	var names []string
+	if kind == history.KindDelay {
+		names = make([]string, 0, len(allProxies))
+		for name := range allProxies {
+			names = append(names, name)
+		}
+	} else {
+		names = filter.FilterProxies(*filterRegexConfig, allProxies)
+	}
+	return runTests(ctx, kind, names, allProxies, sources, opts, weights, patterns)
+}
+
+// testMu 避免定时任务与 API 任务同时测试而互相影响带宽
+var testMu sync.Mutex
+
+// runTests 测试 names 中的节点并补充节点信息与评分，同一时间只运行一次测试。
+// 返回的 start 为取得锁、实际开始测试的时间，不包括等待其他测试的时间
+func runTests(ctx context.Context, kind string, names []string, proxies map[string]config.CProxy, sources map[string]string, opts tester.Options, weights result.ScoreWeights, patterns []*regexp.Regexp) (results []result.Result, start time.Time) {
+	testMu.Lock()
+	defer testMu.Unlock()
+	start = time.Now()
+
+	// 流量上限按每次运行计算
+	var traffic int64
+	opts.Traffic = &traffic
+
+	if kind == history.KindDelay {
+		selected := make(map[string]config.CProxy, len(names))
+		for _, name := range names {
+			selected[name] = proxies[name]
+		}
+		results = tester.TestProxiesDelay(ctx, selected, opts)
+	} else {
+		results = tester.TestProxies(ctx, names, proxies, opts)
+	}
+	annotateSources(results, proxies, sources)
+	nodename.AnnotateRegions(results)
+	nodename.AnnotateMultipliers(results, patterns)
+	result.ScoreResults(results, weights)
+	return results, start
+}
+
+// recorder 保存完成的测试：写入历史数据库并删除超过 -retention 的旧数据，
+// 同时更新指标与 API 的最新结果
+type recorder struct {
+	store    *history.Store
+	exporter *metrics.Exporter
+	server   *api.Server
+}
+
+// record 保存一次运行的结果，partial 表示只测试了部分节点，其余节点的最新结果保持不变
+func (r *recorder) record(kind string, start time.Time, results []result.Result, partial bool) {
+	if err := r.store.Add(history.Run{Time: start, Kind: kind, Results: results, Partial: partial}); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save history: %v\n", err)
+	}
+	if *retention > 0 {
+		if removed, err := r.store.Prune(time.Now().Add(-*retention)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to prune history: %v\n", err)
+		} else if removed > 0 {
+			fmt.Printf("Pruned %d runs older than %s\n", removed, *retention)
+		}
+	}
+	if r.exporter != nil {
+		r.exporter.Observe(kind, start, time.Since(start), results, partial)
+	}
+	if r.server != nil {
+		r.server.SetLatest(kind, start, results, partial)
+	}
+}
+
+// isLoopback 判断监听地址是否只能从本机访问，":9090" 等未指定主机的地址监听所有网卡
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serve 在 addr 上启动 HTTP 服务
+func serve(addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := http.Serve(ln, handler); err != nil {
+			fmt.Fprintf(os.Stderr, "HTTP server stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("Listening on http://%s\n", ln.Addr())
+	return nil
+}
+
+// runHistory 实现 history 子命令，查询历史数据库中各节点的可靠性或单个节点的变化趋势
+func runHistory(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	db := fs.String("db", "history.db", "History database written by -daemon")
+	since := fs.Duration("since", 7*24*time.Hour, "Only include runs within this period")
+	kind := fs.String("kind", "", "Only include 'delay' or 'bandwidth' runs, default both")
+	node := fs.String("node", "", "Show the trend of this node instead of the reliability of all nodes")
+	fs.Parse(args)
+
+	if *kind != "" && *kind != history.KindDelay && *kind != history.KindBandwidth {
+		fmt.Fprintf(os.Stderr, "Unsupported kind: %s\n", *kind)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(*db); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open history: %v\n", err)
+		os.Exit(1)
+	}
+	store, err := history.Open(*db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open history: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+
+	from := time.Now().Add(-*since)
+	runs, err := store.Runs(from, *kind)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read history: %v\n", err)
+		os.Exit(1)
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs recorded in this period")
+		return
+	}
+
+	if *node != "" {
+		trend := history.TrendOf(runs, *node)
+		if len(trend.Points) == 0 {
+			fmt.Printf("No results for %s in this period\n", *node)
+			return
+		}
+		history.DisplayTrend(trend)
+		return
+	}
+	history.DisplayReliability(history.ReliabilityOf(runs), from)
+}
@@ -0,0 +1,17 @@
+// Package dashboard 提供编译进程序的网页控制面板，通过 -serve 的 /api/ 接口显示结果与历史并触发测试
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler 返回面板的静态文件服务，需挂载在 prefix 下，如 "/ui/"
+func Handler(prefix string) http.Handler {
+	files, _ := fs.Sub(static, "static")
+	return http.StripPrefix(prefix, http.FileServer(http.FS(files)))
+}
@@ -0,0 +1,22 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/ui/", Handler("/ui/"))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/ui/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "/api/results") {
+		t.Errorf("GET /ui/: status %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("content type %q", ct)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/0x10240/mihomo-speedtest/diff"
+	"github.com/0x10240/mihomo-speedtest/output"
+)
+
+// runDiff 实现 diff 子命令，比较两次 -w json 的结果，用于查看订阅更新后节点的变化
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	threshold := fs.Float64("threshold", 10, "Only report bandwidth, TTFB and delay changes beyond this percentage")
+	format := fs.String("format", "table", "Output format: 'table' or 'json'")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s diff [flags] old.json new.json\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *format != "table" && *format != "json" {
+		fmt.Fprintf(os.Stderr, "Unsupported format: %s\n", *format)
+		os.Exit(1)
+	}
+	before, err := output.ReadReport(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read results: %v\n", err)
+		os.Exit(1)
+	}
+	after, err := output.ReadReport(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read results: %v\n", err)
+		os.Exit(1)
+	}
+
+	changes := diff.Compare(before.Results, after.Results, *threshold)
+	if *format == "json" {
+		data, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	diff.Write(os.Stdout, changes)
+}
@@ -0,0 +1,216 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+	"github.com/olekukonko/tablewriter"
+)
+
+const (
+	Added     = "added"
+	Removed   = "removed"
+	Failed    = "failed"
+	Recovered = "recovered"
+	Changed   = "changed"
+	// Renamed 表示按入口服务器与协议匹配到的改名节点，结果没有明显变化
+	Renamed = "renamed"
+)
+
+// Change 为一个节点在两次结果之间的变化。
+// 按入口服务器与协议匹配到的改名节点，OldName 为旧名称。
+// *Change 为相对旧值的变化百分比，未超过阈值或无法比较时为 0
+type Change struct {
+	Name    string `json:"name"`
+	OldName string `json:"old_name,omitempty"`
+	Type    string `json:"type"`
+	Server  string `json:"server,omitempty"`
+
+	OldBandwidth    float64       `json:"old_bandwidth"`
+	NewBandwidth    float64       `json:"new_bandwidth"`
+	BandwidthChange float64       `json:"bandwidth_change,omitempty"`
+	OldTTFB         time.Duration `json:"old_ttfb"`
+	NewTTFB         time.Duration `json:"new_ttfb"`
+	TTFBChange      float64       `json:"ttfb_change,omitempty"`
+	OldDelay        uint16        `json:"old_delay"`
+	NewDelay        uint16        `json:"new_delay"`
+	DelayChange     float64       `json:"delay_change,omitempty"`
+}
+
+// working 判断节点是否可用：测得带宽或延迟即为可用
+func working(r result.Result) bool {
+	return r.Bandwidth > 0 || (r.Delay > 0 && r.Delay != 9999)
+}
+
+// Compare 比较两次结果，返回新增、删除、状态变化以及带宽或延迟变化超过 threshold（百分比）的节点。
+// 节点先按名称匹配，剩余节点的入口服务器（host:port）与协议在两边都唯一时按它们匹配，以识别改名的节点。
+// 因流量上限被跳过的节点不比较状态与数值。
+func Compare(before, after []result.Result, threshold float64) []Change {
+	oldByName := make(map[string]result.Result, len(before))
+	for _, r := range before {
+		oldByName[r.Name] = r
+	}
+	matched := make(map[string]bool, len(before))
+	pending := make([]result.Result, 0)
+	changes := make([]Change, 0)
+	for _, r := range after {
+		if o, ok := oldByName[r.Name]; ok {
+			matched[r.Name] = true
+			if c, ok := compare(o, r, threshold); ok {
+				changes = append(changes, c)
+			}
+			continue
+		}
+		pending = append(pending, r)
+	}
+
+	unmatched := make([]result.Result, 0)
+	for _, r := range before {
+		if !matched[r.Name] {
+			unmatched = append(unmatched, r)
+		}
+	}
+	oldByServer := uniqueByServer(unmatched)
+	newByServer := uniqueByServer(pending)
+	for _, r := range pending {
+		o, ok := oldByServer[serverKey(r)]
+		if !ok || newByServer[serverKey(r)].Name != r.Name {
+			changes = append(changes, Change{Name: r.Name, Type: Added, Server: r.Server, NewBandwidth: r.Bandwidth, NewTTFB: r.TTFB, NewDelay: r.Delay})
+			continue
+		}
+		matched[o.Name] = true
+		c, ok := compare(o, r, threshold)
+		if !ok {
+			c.Type = Renamed
+		}
+		c.OldName = o.Name
+		changes = append(changes, c)
+	}
+	for _, o := range unmatched {
+		if !matched[o.Name] {
+			changes = append(changes, Change{Name: o.Name, Type: Removed, Server: o.Server, OldBandwidth: o.Bandwidth, OldTTFB: o.TTFB, OldDelay: o.Delay})
+		}
+	}
+	return changes
+}
+
+// serverKey 为按入口服务器匹配节点所用的键，同一 host:port 上的不同协议是不同的节点
+func serverKey(r result.Result) string {
+	return r.Type + "://" + r.Server
+}
+
+// uniqueByServer 返回入口服务器与协议只对应一个节点的映射
+func uniqueByServer(results []result.Result) map[string]result.Result {
+	count := make(map[string]int, len(results))
+	for _, r := range results {
+		count[serverKey(r)]++
+	}
+	unique := make(map[string]result.Result, len(results))
+	for _, r := range results {
+		if r.Server != "" && count[serverKey(r)] == 1 {
+			unique[serverKey(r)] = r
+		}
+	}
+	return unique
+}
+
+// compare 比较同一节点的两次结果，没有值得报告的变化时返回 false
+func compare(o, n result.Result, threshold float64) (Change, bool) {
+	c := Change{
+		Name:         n.Name,
+		Server:       n.Server,
+		OldBandwidth: o.Bandwidth,
+		NewBandwidth: n.Bandwidth,
+		OldTTFB:      o.TTFB,
+		NewTTFB:      n.TTFB,
+		OldDelay:     o.Delay,
+		NewDelay:     n.Delay,
+	}
+	if o.Skipped || n.Skipped {
+		return c, false
+	}
+	switch {
+	case working(o) && !working(n):
+		c.Type = Failed
+		return c, true
+	case !working(o) && working(n):
+		c.Type = Recovered
+		return c, true
+	case !working(n):
+		return c, false
+	}
+
+	c.BandwidthChange = percentChange(o.Bandwidth, n.Bandwidth, threshold)
+	c.TTFBChange = percentChange(float64(o.TTFB), float64(n.TTFB), threshold)
+	if o.Delay != 9999 && n.Delay != 9999 {
+		c.DelayChange = percentChange(float64(o.Delay), float64(n.Delay), threshold)
+	}
+	if c.BandwidthChange == 0 && c.TTFBChange == 0 && c.DelayChange == 0 {
+		return c, false
+	}
+	c.Type = Changed
+	return c, true
+}
+
+// percentChange 返回相对旧值的变化百分比，任一值未测得或变化未超过阈值时返回 0
+func percentChange(before, after, threshold float64) float64 {
+	if before <= 0 || after <= 0 {
+		return 0
+	}
+	change := (after - before) / before * 100
+	if change < threshold && change > -threshold {
+		return 0
+	}
+	return change
+}
+
+// Write 以表格输出变化，按新增、删除、失效、恢复、数值变化、改名的顺序分组
+func Write(w io.Writer, changes []Change) {
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "No changes")
+		return
+	}
+
+	counts := make(map[string]int)
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"Change", "Node", "Bandwidth", "TTFB", "Delay"})
+	for _, typ := range []string{Added, Removed, Failed, Recovered, Changed, Renamed} {
+		for _, c := range changes {
+			if c.Type != typ {
+				continue
+			}
+			counts[typ]++
+			name := c.Name
+			if c.OldName != "" {
+				name = c.OldName + " -> " + c.Name
+			}
+			table.Append([]string{
+				typ,
+				name,
+				formatDelta(result.FormatBandwidth(c.OldBandwidth), result.FormatBandwidth(c.NewBandwidth), c.BandwidthChange, typ),
+				formatDelta(result.FormatMilliseconds(c.OldTTFB), result.FormatMilliseconds(c.NewTTFB), c.TTFBChange, typ),
+				formatDelta(result.FormatDelay(c.OldDelay), result.FormatDelay(c.NewDelay), c.DelayChange, typ),
+			})
+		}
+	}
+	table.Render()
+	fmt.Fprintf(w, "%d added, %d removed, %d failed, %d recovered, %d changed, %d renamed\n",
+		counts[Added], counts[Removed], counts[Failed], counts[Recovered], counts[Changed], counts[Renamed])
+}
+
+func formatDelta(before, after string, change float64, typ string) string {
+	switch typ {
+	case Added, Renamed:
+		return after
+	case Removed:
+		return before
+	case Changed:
+		if change == 0 {
+			return after
+		}
+		return fmt.Sprintf("%s -> %s (%+.0f%%)", before, after, change)
+	}
+	return before + " -> " + after
+}
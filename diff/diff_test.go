@@ -0,0 +1,68 @@
+package diff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+func TestCompare(t *testing.T) {
+	before := []result.Result{
+		{Name: "same", Server: "a.example.com:443", Type: "Vmess", Bandwidth: 100, TTFB: 100 * time.Millisecond},
+		{Name: "slower", Server: "b.example.com:443", Type: "Vmess", Bandwidth: 100, TTFB: 100 * time.Millisecond},
+		{Name: "broken", Server: "c.example.com:443", Type: "Vmess", Bandwidth: 100},
+		{Name: "fixed", Server: "d.example.com:443", Type: "Vmess"},
+		{Name: "HK 01", Server: "e.example.com:443", Type: "Vmess", Bandwidth: 100},
+		{Name: "gone", Server: "shared.example.com:443", Type: "Vmess", Bandwidth: 100},
+		{Name: "gone too", Server: "shared.example.com:443", Type: "Vmess", Bandwidth: 100},
+		{Name: "skipped", Server: "f.example.com:443", Type: "Vmess", Bandwidth: 100},
+		{Name: "old port", Server: "g.example.com:443", Type: "Vmess", Bandwidth: 100},
+		{Name: "old type", Server: "h.example.com:443", Type: "Vmess", Bandwidth: 100},
+	}
+	after := []result.Result{
+		{Name: "same", Server: "a.example.com:443", Type: "Vmess", Bandwidth: 105, TTFB: 95 * time.Millisecond},
+		{Name: "slower", Server: "b.example.com:443", Type: "Vmess", Bandwidth: 50, TTFB: 100 * time.Millisecond},
+		{Name: "broken", Server: "c.example.com:443", Type: "Vmess"},
+		{Name: "fixed", Server: "d.example.com:443", Type: "Vmess", Bandwidth: 100},
+		{Name: "香港 01", Server: "e.example.com:443", Type: "Vmess", Bandwidth: 100},
+		{Name: "new", Server: "shared.example.com:443", Type: "Vmess", Bandwidth: 100},
+		{Name: "skipped", Server: "f.example.com:443", Type: "Vmess", Skipped: true},
+		// 同一主机的不同端口或协议不是改名
+		{Name: "new port", Server: "g.example.com:8443", Type: "Vmess", Bandwidth: 100},
+		{Name: "new type", Server: "h.example.com:443", Type: "Trojan", Bandwidth: 100},
+	}
+
+	changes := Compare(before, after, 10)
+	got := make(map[string]Change, len(changes))
+	for _, c := range changes {
+		got[c.Name] = c
+	}
+	want := map[string]string{
+		"slower":   Changed,
+		"broken":   Failed,
+		"fixed":    Recovered,
+		"香港 01":    Renamed,
+		"new":      Added,
+		"gone":     Removed,
+		"gone too": Removed,
+		"old port": Removed,
+		"new port": Added,
+		"old type": Removed,
+		"new type": Added,
+	}
+	if len(got) != len(want) {
+		t.Errorf("Compare() = %+v", changes)
+	}
+	for name, typ := range want {
+		if got[name].Type != typ {
+			t.Errorf("%s: type = %q, want %q", name, got[name].Type, typ)
+		}
+	}
+	if c := got["slower"]; c.BandwidthChange != -50 || c.TTFBChange != 0 {
+		t.Errorf("slower: %+v", c)
+	}
+	if got["香港 01"].OldName != "HK 01" {
+		t.Errorf("renamed node: %+v", got["香港 01"])
+	}
+}
@@ -0,0 +1,118 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Info 为本地数据库中查到的 IP 信息
+type Info struct {
+	Country string
+	City    string
+	ASN     uint32
+	Org     string
+}
+
+// DB 读取 MaxMind 格式的 GeoIP（Country/City）与 ASN 数据库，两者都是可选的
+type DB struct {
+	geo *maxminddb.Reader
+	asn *maxminddb.Reader
+}
+
+func Open(geoPath string, asnPath string) (*DB, error) {
+	db := &DB{}
+	var err error
+	if geoPath != "" {
+		if db.geo, err = maxminddb.Open(geoPath); err != nil {
+			return nil, fmt.Errorf("open geoip database: %v", err)
+		}
+	}
+	if asnPath != "" {
+		if db.asn, err = maxminddb.Open(asnPath); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("open asn database: %v", err)
+		}
+	}
+	return db, nil
+}
+
+func (db *DB) Close() error {
+	if db.geo != nil {
+		db.geo.Close()
+	}
+	if db.asn != nil {
+		db.asn.Close()
+	}
+	return nil
+}
+
+func (db *DB) Lookup(ip net.IP) Info {
+	info := Info{}
+	if ip == nil {
+		return info
+	}
+
+	if db.geo != nil {
+		var record any
+		if err := db.geo.Lookup(ip, &record); err == nil {
+			switch record := record.(type) {
+			case string:
+				// sing-geoip 等格式直接以国家代码作为记录
+				info.Country = strings.ToUpper(record)
+			case map[string]any:
+				info.Country = lookupString(record, "country", "iso_code")
+				info.City = lookupString(record, "city", "names", "en")
+			}
+		}
+	}
+
+	if db.asn != nil {
+		var record struct {
+			Number uint32 `maxminddb:"autonomous_system_number"`
+			Org    string `maxminddb:"autonomous_system_organization"`
+		}
+		if err := db.asn.Lookup(ip, &record); err == nil {
+			info.ASN = record.Number
+			info.Org = record.Org
+		}
+	}
+	return info
+}
+
+func lookupString(record map[string]any, path ...string) string {
+	var value any = record
+	for _, key := range path {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = m[key]
+	}
+	s, _ := value.(string)
+	return s
+}
+
+// hostingKeywords 为常见云服务与 IDC 的组织名称关键字
+var hostingKeywords = []string{
+	"hosting", "cloud", "data center", "datacenter", "server", "vps",
+	"amazon", "google", "microsoft", "oracle", "alibaba", "tencent", "huawei",
+	"digitalocean", "linode", "akamai", "vultr", "choopa", "ovh", "hetzner",
+	"m247", "leaseweb", "contabo", "colocrossing", "bandwagon", "it7", "dmit",
+}
+
+// Classify 根据 ASN 组织名称粗略判断是 IDC 出口（hosting）还是普通运营商出口（isp）
+func Classify(org string) string {
+	if org == "" {
+		return ""
+	}
+	lower := strings.ToLower(org)
+	for _, keyword := range hostingKeywords {
+		if strings.Contains(lower, keyword) {
+			return "hosting"
+		}
+	}
+	return "isp"
+}
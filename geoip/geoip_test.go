@@ -0,0 +1,195 @@
+package geoip
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		org      string
+		expected string
+	}{
+		{org: "AMAZON-02", expected: "hosting"},
+		{org: "DigitalOcean, LLC", expected: "hosting"},
+		{org: "Hetzner Online GmbH", expected: "hosting"},
+		{org: "Chunghwa Telecom Co., Ltd.", expected: "isp"},
+		{org: "Comcast Cable Communications, LLC", expected: "isp"},
+		{org: "", expected: ""},
+	}
+
+	for _, test := range tests {
+		if result := Classify(test.org); result != test.expected {
+			t.Errorf("Classify(%q) = %q; want %q", test.org, result, test.expected)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	city := filepath.Join(dir, "city.mmdb")
+	writeMMDB(t, city, map[string]any{
+		"1.1.1.0/24": map[string]any{
+			"country": map[string]any{"iso_code": "AU"},
+			"city":    map[string]any{"names": map[string]any{"en": "Sydney"}},
+		},
+	})
+	asn := filepath.Join(dir, "asn.mmdb")
+	writeMMDB(t, asn, map[string]any{
+		"1.1.1.0/24": map[string]any{"autonomous_system_number": uint32(13335), "autonomous_system_organization": "CLOUDFLARENET"},
+	})
+	country := filepath.Join(dir, "country.mmdb")
+	writeMMDB(t, country, map[string]any{"8.8.8.0/24": "us"})
+
+	db, err := Open(city, asn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	want := Info{Country: "AU", City: "Sydney", ASN: 13335, Org: "CLOUDFLARENET"}
+	if got := db.Lookup(net.ParseIP("1.1.1.1")); got != want {
+		t.Errorf("Lookup(1.1.1.1) = %+v, want %+v", got, want)
+	}
+	if got := db.Lookup(net.ParseIP("9.9.9.9")); got != (Info{}) {
+		t.Errorf("Lookup(9.9.9.9) = %+v, want empty", got)
+	}
+	if got := db.Lookup(nil); got != (Info{}) {
+		t.Errorf("Lookup(nil) = %+v, want empty", got)
+	}
+
+	// sing-geoip 格式以国家代码作为记录，没有 ASN 数据库
+	db, err = Open(country, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got := db.Lookup(net.ParseIP("8.8.8.8")); got != (Info{Country: "US"}) {
+		t.Errorf("Lookup(8.8.8.8) = %+v", got)
+	}
+}
+
+// writeMMDB 写出只包含 networks 中 IPv4 网段的 MaxMind DB（24 位记录），网段之间不能重叠
+func writeMMDB(t *testing.T, path string, networks map[string]any) {
+	const empty = -1
+	// records 中非负值为子节点，empty 为没有数据，其余 -(2+i) 为第 i 条数据
+	records := [][2]int{{empty, empty}}
+	var data []byte
+	var offsets []int
+	for cidr, value := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, len(data))
+		data = append(data, encodeMMDB(value)...)
+		ones, _ := network.Mask.Size()
+		ip := network.IP.To4()
+		node := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip[i/8]>>(7-i%8)) & 1
+			if i == ones-1 {
+				records[node][bit] = -(2 + len(offsets) - 1)
+				break
+			}
+			if records[node][bit] < 0 {
+				records = append(records, [2]int{empty, empty})
+				records[node][bit] = len(records) - 1
+			}
+			node = records[node][bit]
+		}
+	}
+
+	nodeCount := len(records)
+	var buf bytes.Buffer
+	for _, node := range records {
+		for _, r := range node {
+			v := r
+			switch {
+			case r == empty:
+				v = nodeCount
+			case r < 0:
+				v = nodeCount + 16 + offsets[-r-2]
+			}
+			buf.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	buf.Write(make([]byte, 16))
+	buf.Write(data)
+	buf.WriteString("\xAB\xCD\xEFMaxMind.com")
+	buf.Write(encodeMMDB(map[string]any{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(4),
+		"database_type":               "test",
+		"languages":                   []any{"en"},
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint32(0),
+		"description":                 map[string]any{"en": "test"},
+	}))
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// encodeMMDB 按 MaxMind DB 数据格式编码字符串、map、数组与无符号整数
+func encodeMMDB(value any) []byte {
+	control := func(typ, size int) []byte {
+		var b []byte
+		if typ <= 7 {
+			b = []byte{byte(typ << 5)}
+		} else {
+			b = []byte{0, byte(typ - 7)}
+		}
+		switch {
+		case size < 29:
+			b[0] |= byte(size)
+		case size < 285:
+			b[0] |= 29
+			b = append(b, byte(size-29))
+		default:
+			b[0] |= 30
+			b = append(b, byte((size-285)>>8), byte(size-285))
+		}
+		return b
+	}
+	unsigned := func(typ int, v uint64) []byte {
+		var digits []byte
+		for ; v > 0; v >>= 8 {
+			digits = append([]byte{byte(v)}, digits...)
+		}
+		return append(control(typ, len(digits)), digits...)
+	}
+	switch v := value.(type) {
+	case string:
+		return append(control(2, len(v)), v...)
+	case uint16:
+		return unsigned(5, uint64(v))
+	case uint32:
+		return unsigned(6, uint64(v))
+	case []any:
+		b := control(11, len(v))
+		for _, item := range v {
+			b = append(b, encodeMMDB(item)...)
+		}
+		return b
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b := control(7, len(v))
+		for _, key := range keys {
+			b = append(b, encodeMMDB(key)...)
+			b = append(b, encodeMMDB(v[key])...)
+		}
+		return b
+	}
+	panic(fmt.Sprintf("unsupported mmdb value %T", value))
+}
@@ -0,0 +1,62 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+	"github.com/olekukonko/tablewriter"
+)
+
+const timeLayout = "2006-01-02 15:04"
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Local().Format(timeLayout)
+}
+
+// DisplayReliability 输出各节点的成功率与成功各次的平均结果
+func DisplayReliability(reliability []Reliability, since time.Time) {
+	fmt.Printf("\nReliability since %s:\n", formatTime(since))
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Node", "Runs", "Success", "Bandwidth", "TTFB", "Delay", "Last Success"})
+	for _, r := range reliability {
+		delay := "N/A"
+		if r.Delay > 0 {
+			delay = fmt.Sprintf("%.0fms", r.Delay)
+		}
+		table.Append([]string{
+			r.Name,
+			fmt.Sprintf("%d", r.Runs),
+			fmt.Sprintf("%.1f%%", r.Rate()*100),
+			result.FormatBandwidth(r.Bandwidth),
+			result.FormatMilliseconds(r.TTFB),
+			delay,
+			formatTime(r.LastSuccess),
+		})
+	}
+	table.Render()
+}
+
+// DisplayTrend 输出节点每次运行的结果与线性拟合的每日变化
+func DisplayTrend(trend Trend) {
+	fmt.Printf("\nTrend of %s:\n", trend.Name)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Time", "Kind", "Status", "Bandwidth", "TTFB", "Delay"})
+	for _, p := range trend.Points {
+		status := "ok"
+		if !p.OK {
+			status = "fail"
+		}
+		table.Append([]string{formatTime(p.Time), p.Kind, status, result.FormatBandwidth(p.Bandwidth),
+			result.FormatMilliseconds(p.TTFB), result.FormatDelay(p.Delay)})
+	}
+	table.Render()
+
+	if len(trend.Points) > 1 {
+		fmt.Printf("Bandwidth %+.2fMB/s per day, delay %+.1fms per day\n", trend.BandwidthPerDay/1024/1024, trend.DelayPerDay)
+	}
+}
@@ -0,0 +1,105 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+	"github.com/metacubex/bbolt"
+)
+
+const (
+	KindDelay     = "delay"
+	KindBandwidth = "bandwidth"
+)
+
+var runsBucket = []byte("runs")
+
+// Run 为一次测试的结果
+type Run struct {
+	Time    time.Time       `json:"time"`
+	Kind    string          `json:"kind"`
+	Results []result.Result `json:"results"`
+	// Partial 表示只测试了部分节点（如 API 中指定节点的任务），其余节点的结果沿用之前的运行
+	Partial bool `json:"partial,omitempty"`
+}
+
+// Store 将每次运行的结果保存在本地 bbolt 数据库中，以运行时间为键按时间顺序存放
+type Store struct {
+	db *bbolt.DB
+}
+
+// Open 打开或创建历史数据库，数据库被其他进程占用时等待至多一秒
+func Open(path string) (*Store, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(runsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+// Add 保存一次运行的结果，时间相同的运行会被覆盖
+func (s *Store) Add(run Run) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(runsBucket).Put(timeKey(run.Time), data)
+	})
+}
+
+// Runs 按时间顺序返回 since 之后的运行，kind 为空时返回所有类型
+func (s *Store) Runs(since time.Time, kind string) ([]Run, error) {
+	runs := make([]Run, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(runsBucket).Cursor()
+		for k, v := c.Seek(timeKey(since)); k != nil; k, v = c.Next() {
+			var run Run
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			if kind == "" || run.Kind == kind {
+				runs = append(runs, run)
+			}
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// Prune 删除 before 之前的运行，返回删除的数量
+func (s *Store) Prune(before time.Time) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(runsBucket).Cursor()
+		end := timeKey(before)
+		for k, _ := c.First(); k != nil && string(k) < string(end); k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
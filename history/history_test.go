@@ -0,0 +1,63 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+func TestStore(t *testing.T) {
+	store, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for day := 0; day < 4; day++ {
+		store.Add(Run{
+			Time: base.AddDate(0, 0, day),
+			Kind: KindBandwidth,
+			Results: []result.Result{
+				{Name: "a", Bandwidth: float64(day+1) * 1024 * 1024},
+				{Name: "b", Bandwidth: float64(day % 2)},
+				{Name: "c", Skipped: true},
+			},
+		})
+	}
+	store.Add(Run{Time: base.AddDate(0, 0, 3).Add(time.Hour), Kind: KindDelay, Results: []result.Result{{Name: "a", Delay: 9999}}})
+
+	removed, err := store.Prune(base.AddDate(0, 0, 1))
+	if err != nil || removed != 1 {
+		t.Fatalf("Prune() = %d, %v", removed, err)
+	}
+	runs, err := store.Runs(base, "")
+	if err != nil || len(runs) != 4 {
+		t.Fatalf("Runs() = %d runs, %v", len(runs), err)
+	}
+	if bandwidth, _ := store.Runs(base, KindBandwidth); len(bandwidth) != 3 {
+		t.Errorf("Runs(bandwidth) = %d runs, want 3", len(bandwidth))
+	}
+
+	reliability := ReliabilityOf(runs)
+	if len(reliability) != 2 {
+		t.Fatalf("ReliabilityOf() = %+v", reliability)
+	}
+	a, b := reliability[0], reliability[1]
+	if a.Name != "a" || a.Runs != 4 || a.Successes != 3 || a.Bandwidth != 3*1024*1024 {
+		t.Errorf("reliability of a = %+v", a)
+	}
+	if b.Name != "b" || b.Runs != 3 || b.Successes != 2 {
+		t.Errorf("reliability of b = %+v", b)
+	}
+
+	trend := TrendOf(runs, "a")
+	if len(trend.Points) != 4 || trend.Points[3].OK {
+		t.Errorf("TrendOf() points = %+v", trend.Points)
+	}
+	if trend.BandwidthPerDay != 1024*1024 {
+		t.Errorf("BandwidthPerDay = %f, want %d", trend.BandwidthPerDay, 1024*1024)
+	}
+}
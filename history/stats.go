@@ -0,0 +1,172 @@
+package history
+
+import (
+	"sort"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+// Reliability 为节点在一段时间内的可用性统计，Bandwidth、TTFB、Delay 为成功各次的均值
+type Reliability struct {
+	Name        string        `json:"name"`
+	Runs        int           `json:"runs"`
+	Successes   int           `json:"successes"`
+	Bandwidth   float64       `json:"bandwidth"`
+	TTFB        time.Duration `json:"ttfb"`
+	Delay       float64       `json:"delay"`
+	LastSuccess time.Time     `json:"last_success"`
+}
+
+func (r Reliability) Rate() float64 {
+	if r.Runs == 0 {
+		return 0
+	}
+	return float64(r.Successes) / float64(r.Runs)
+}
+
+// succeeded 判断节点在一次运行中是否可用：延迟测试要求测得延迟，带宽测试要求测得带宽
+func succeeded(kind string, r result.Result) bool {
+	if kind == KindDelay {
+		return r.Delay > 0 && r.Delay != 9999
+	}
+	return r.Bandwidth > 0
+}
+
+// ReliabilityOf 统计各节点在 runs 中的成功率，按成功率从高到低排序。
+// 因流量上限被跳过的节点不计入。
+func ReliabilityOf(runs []Run) []Reliability {
+	type sums struct {
+		Reliability
+		bandwidthN, delayN int
+		ttfb               time.Duration
+	}
+	stats := make(map[string]*sums)
+	order := make([]string, 0)
+	for _, run := range runs {
+		for _, res := range run.Results {
+			if res.Skipped {
+				continue
+			}
+			s, ok := stats[res.Name]
+			if !ok {
+				s = &sums{Reliability: Reliability{Name: res.Name}}
+				stats[res.Name] = s
+				order = append(order, res.Name)
+			}
+			s.Runs++
+			if !succeeded(run.Kind, res) {
+				continue
+			}
+			s.Successes++
+			if run.Time.After(s.LastSuccess) {
+				s.LastSuccess = run.Time
+			}
+			if res.Bandwidth > 0 {
+				s.bandwidthN++
+				s.Bandwidth += res.Bandwidth
+				s.ttfb += res.TTFB
+			}
+			if res.Delay > 0 && res.Delay != 9999 {
+				s.delayN++
+				s.Delay += float64(res.Delay)
+			}
+		}
+	}
+
+	reliability := make([]Reliability, 0, len(order))
+	for _, name := range order {
+		s := stats[name]
+		if s.bandwidthN > 0 {
+			s.Bandwidth /= float64(s.bandwidthN)
+			s.TTFB = s.ttfb / time.Duration(s.bandwidthN)
+		}
+		if s.delayN > 0 {
+			s.Delay /= float64(s.delayN)
+		}
+		reliability = append(reliability, s.Reliability)
+	}
+	sort.SliceStable(reliability, func(i, j int) bool {
+		if reliability[i].Rate() != reliability[j].Rate() {
+			return reliability[i].Rate() > reliability[j].Rate()
+		}
+		return reliability[i].Bandwidth > reliability[j].Bandwidth
+	})
+	return reliability
+}
+
+// Point 为节点在一次运行中的结果
+type Point struct {
+	Time      time.Time     `json:"time"`
+	Kind      string        `json:"kind"`
+	OK        bool          `json:"ok"`
+	Bandwidth float64       `json:"bandwidth"`
+	TTFB      time.Duration `json:"ttfb"`
+	Delay     uint16        `json:"delay"`
+}
+
+// Trend 为节点结果随时间的变化，BandwidthPerDay、DelayPerDay 为成功各次按时间线性拟合的每日变化量
+type Trend struct {
+	Name            string  `json:"name"`
+	Points          []Point `json:"points"`
+	BandwidthPerDay float64 `json:"bandwidth_per_day"`
+	DelayPerDay     float64 `json:"delay_per_day"`
+}
+
+// TrendOf 返回节点 name 在 runs 中的结果序列与变化趋势
+func TrendOf(runs []Run, name string) Trend {
+	trend := Trend{Name: name, Points: make([]Point, 0)}
+	var bandwidthX, bandwidthY, delayX, delayY []float64
+	for _, run := range runs {
+		for _, res := range run.Results {
+			if res.Name != name || res.Skipped {
+				continue
+			}
+			p := Point{
+				Time:      run.Time,
+				Kind:      run.Kind,
+				OK:        succeeded(run.Kind, res),
+				Bandwidth: res.Bandwidth,
+				TTFB:      res.TTFB,
+				Delay:     res.Delay,
+			}
+			trend.Points = append(trend.Points, p)
+
+			days := run.Time.Sub(runs[0].Time).Hours() / 24
+			if res.Bandwidth > 0 {
+				bandwidthX = append(bandwidthX, days)
+				bandwidthY = append(bandwidthY, res.Bandwidth)
+			}
+			if res.Delay > 0 && res.Delay != 9999 {
+				delayX = append(delayX, days)
+				delayY = append(delayY, float64(res.Delay))
+			}
+		}
+	}
+	trend.BandwidthPerDay = slope(bandwidthX, bandwidthY)
+	trend.DelayPerDay = slope(delayX, delayY)
+	return trend
+}
+
+// slope 返回最小二乘拟合直线的斜率，数据不足时返回 0
+func slope(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	if len(xs) < 2 {
+		return 0
+	}
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var cov, variance float64
+	for i := range xs {
+		cov += (xs[i] - meanX) * (ys[i] - meanY)
+		variance += (xs[i] - meanX) * (xs[i] - meanX)
+	}
+	if variance == 0 {
+		return 0
+	}
+	return cov / variance
+}
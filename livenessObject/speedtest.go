@@ -1,16 +1,67 @@
 package main
 
 import (
+	"flag"
+	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/stun"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+var (
+	stunPrimaryIP   = flag.String("stun-ip", "", "Primary IP of the STUN stand-in, empty disables it")
+	stunAlternateIP = flag.String("stun-alt-ip", "", "Alternate IP of the STUN stand-in, needed for full NAT filtering tests")
 )
 
+// udpEcho 将收到的数据报原样返回，用于 -udp-mode echo
+func udpEcho(addr string) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Printf("udp echo disabled: %v", err)
+		return
+	}
+	defer pc.Close()
+
+	buf := make([]byte, 2048)
+	for {
+		n, raddr, err := pc.ReadFrom(buf)
+		if err != nil {
+			continue
+		}
+		pc.WriteTo(buf[:n], raddr)
+	}
+}
+
 func main() {
+	flag.Parse()
+
+	go udpEcho(":8080")
+	if *stunPrimaryIP != "" {
+		s, err := stun.Listen(*stunPrimaryIP, *stunAlternateIP, 3478, 3479)
+		if err != nil {
+			log.Fatalf("stun: %v", err)
+		}
+		go s.Serve()
+	}
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Header().Add("Content-Type", "text/html")
 		w.Write([]byte(`<h1>SpeedTest Works</h1>`))
 	})
+	http.HandleFunc("/ip", func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		w.Header().Add("Content-Type", "text/plain")
+		w.Write([]byte(host))
+	})
 	http.HandleFunc("/liveness", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 	})
@@ -37,5 +88,67 @@ func main() {
 		}
 		w.Write(zeroBytes[:byteSize%len(zeroBytes)])
 	})
+	// 按 rate（字节/秒）持续输出数据直到客户端断开，用于 -soak-mode stream
+	http.HandleFunc("/_slow", func(w http.ResponseWriter, r *http.Request) {
+		rate, err := strconv.Atoi(r.URL.Query().Get("rate"))
+		if err != nil || rate <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid rate"))
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/octet-stream")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		const interval = 250 * time.Millisecond
+		chunk := rate / int(time.Second/interval)
+		if chunk == 0 {
+			chunk = 1
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+			for n := chunk; n > 0; n -= len(zeroBytes) {
+				size := n
+				if size > len(zeroBytes) {
+					size = len(zeroBytes)
+				}
+				if _, err := w.Write(zeroBytes[:size]); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	})
+
+	// websocket 回显，用于 -soak-mode ws
+	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, _, _, err := ws.UpgradeHTTP(r, w)
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				msg, op, err := wsutil.ReadClientData(conn)
+				if err != nil {
+					return
+				}
+				if err := wsutil.WriteServerMessage(conn, op, msg); err != nil {
+					return
+				}
+			}
+		}()
+	})
 	http.ListenAndServe(":8080", nil)
 }
@@ -1,36 +1,115 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/0x10240/mihomo-speedtest/api"
+	"github.com/0x10240/mihomo-speedtest/checkpoint"
 	"github.com/0x10240/mihomo-speedtest/config"
+	"github.com/0x10240/mihomo-speedtest/dashboard"
 	"github.com/0x10240/mihomo-speedtest/filter"
+	"github.com/0x10240/mihomo-speedtest/geoip"
+	"github.com/0x10240/mihomo-speedtest/history"
+	"github.com/0x10240/mihomo-speedtest/metrics"
+	"github.com/0x10240/mihomo-speedtest/nodename"
+	"github.com/0x10240/mihomo-speedtest/outbound"
 	"github.com/0x10240/mihomo-speedtest/output"
+	"github.com/0x10240/mihomo-speedtest/policy"
+	"github.com/0x10240/mihomo-speedtest/progress"
 	"github.com/0x10240/mihomo-speedtest/result"
 	"github.com/0x10240/mihomo-speedtest/tester"
 )
 
+// version 在发布构建时通过 -ldflags "-X main.version=..." 设置
+var version = "dev"
+
 var (
 	livenessObject     = flag.String("l", "https://speed.cloudflare.com/__down?bytes=%d", "URL of the target to test, supports custom size")
 	configPathConfig   = flag.String("c", "", "Configuration file path or URL")
 	filterRegexConfig  = flag.String("f", ".*", "Filter node names using regular expressions")
-	downloadSizeConfig = flag.Int("size", 100, "Download size for testing (in MB)")
+	downloadSizeConfig = flag.Int("size", 100, "Download size for testing (in MB), the per-node ceiling with -adaptive")
 	timeoutConfig      = flag.Duration("timeout", 5*time.Second, "Timeout duration for testing")
-	sortField          = flag.String("sort", "b", "Sort field: 'b' for bandwidth, 't' for latency")
+	sortField          = flag.String("sort", "b", "Comma-separated sort fields: bandwidth (b), ttfb (t), delay (d), jitter (j), cost (c, bandwidth per traffic multiplier), score (s), country, name; prefix '-' for descending or '+' for ascending, failed nodes always last")
+	scoreWeights       = flag.String("score-weights", "", "Score weights, e.g. 'bandwidth=0.4,ttfb=0.2,delay=0.2,jitter=0.1,success=0.1' (default weights as in this example)")
 	outputFormat       = flag.String("w", "", "Output results to 'json' or 'csv' or 'yaml' file")
 	outputFile         = flag.String("o", "", "Test result output filepath")
+	adaptive           = flag.Bool("adaptive", false, "Probe each node with a small download first and size the main download to last -target-duration")
+	probeSize          = flag.Int("probe-size", 1, "Probe download size for -adaptive (in MB)")
+	targetDuration     = flag.Duration("target-duration", 3*time.Second, "Target duration of the main download for -adaptive, must be shorter than -timeout")
+	bufferbloat        = flag.Bool("bufferbloat", false, "Keep probing -delayurl through each proxy during the download and compare loaded with idle latency")
+	maxTraffic         = flag.Int("max-traffic", 0, "Stop starting new tests once this much traffic (in MB, upload and download) has gone through the proxies, remaining nodes are marked as skipped; 0 means unlimited")
+	sweep              = flag.Int("sweep", 0, "Test each node with 1, 2, 4... concurrent downloads up to this many until bandwidth stops improving, replaces -concurrent; 0 disables the sweep")
+	rounds             = flag.Int("rounds", 1, "Number of bandwidth test rounds, nodes are tested in a random order each round and results are aggregated")
+	cooldown           = flag.Duration("cooldown", 10*time.Second, "Pause between rounds when -rounds is greater than 1")
+	soakDuration       = flag.Duration("soak", 0, "Keep a long-lived stream open through each node for this long and report stalls, resets, reconnects and uptime; 0 disables the soak test")
+	soakMode           = flag.String("soak-mode", "stream", "Soak test mode: 'stream' reads a slow continuous download, 'ws' exchanges websocket echo messages")
+	soakRate           = flag.Int("soak-rate", 16, "Download rate of the 'stream' soak test (in KB/s)")
+	soakURL            = flag.String("soak-url", "", "Soak test URL, derived from -l when it points at livenessObject")
+	soakStall          = flag.Duration("soak-stall", 5*time.Second, "A soak stream without data for this long counts as a stall, after three times as long it is reconnected")
 	concurrent         = flag.Int("concurrent", 4, "Number of concurrent downloads")
 	proxy              = flag.String("proxy", "", "proxy to get resource")
 	forwardProxy       = flag.String("forward-proxy", "", "Forward proxy, supporting SOCKS5 and HTTP proxy.")
 	delayTest          = flag.Bool("delay", false, "only delay testing")
 	delayTestUrl       = flag.String("delayurl", "https://www.gstatic.com/generate_204", "delay test url")
+	delayCount         = flag.Int("delay-count", 1, "Number of delay tests per proxy, jitter is reported when greater than 1")
+	udpTest            = flag.Bool("udp", false, "Also test UDP relay through each proxy")
+	udpTarget          = flag.String("udp-target", "1.1.1.1:53", "UDP test target (host:port)")
+	udpMode            = flag.String("udp-mode", "dns", "UDP test mode: 'dns' sends DNS queries, 'echo' expects datagrams echoed back")
+	udpCount           = flag.Int("udp-count", 5, "Number of UDP probes per proxy")
+	natTest            = flag.Bool("nat", false, "Detect NAT mapping and filtering behaviour of each proxy's UDP relay")
+	stunServer         = flag.String("stun-server", "stun.hot-chilli.net:3478", "RFC 5780 capable STUN server used by -nat")
+	ipLookup           = flag.String("ip-lookup", "cloudflare", "Outbound IP resolvers tried in order: 'cloudflare', 'ipinfo', 'echo', optionally as name=url; 'none' disables the lookup. ipinfo sends every exit IP to ipinfo.io and must be enabled explicitly")
+	ipLookupFields     = flag.String("ip-lookup-fields", "", "Field mapping for the ipinfo resolver, e.g. 'ip=query,country=countryCode,org=isp'")
+	geoipDB            = flag.String("geoip-db", "", "Local MaxMind format GeoIP (Country/City) database used to enrich entry and exit IPs")
+	asnDB              = flag.String("asn-db", "", "Local MaxMind format ASN database used to enrich entry and exit IPs")
+	multiplierPattern  = flag.String("multiplier-regex", "", "Regular expression with a capture group to extract traffic multipliers from node names, replaces the built-in patterns")
+	minBandwidth       = flag.Float64("min-bandwidth", 0, "Policy: minimum bandwidth of a passing node (in MB/s)")
+	maxTTFB            = flag.Duration("max-ttfb", 0, "Policy: maximum TTFB of a passing node")
+	maxDelay           = flag.Duration("max-delay", 0, "Policy: maximum delay of a passing node")
+	minPass            = flag.Int("min-pass", 0, "Policy: minimum number of passing nodes")
+	minPassPercent     = flag.Float64("min-pass-percent", 0, "Policy: minimum percentage of passing nodes")
+	requireCountries   = flag.String("require-countries", "", "Policy: comma-separated countries that must have at least one passing node, e.g. 'HK,JP,US'")
+	checkpointFile     = flag.String("checkpoint", "", "Append each finished result to this file so an interrupted run can be resumed")
+	resume             = flag.Bool("resume", false, "Skip nodes already measured in the -checkpoint file and include their results")
+	plainOutput        = flag.Bool("plain", false, "Print one line per node instead of the live progress display")
+	pairTest           = flag.Bool("pair-test", false, "Test nodes sharing an exit IP or entry server in pairs to detect shared bandwidth")
+	daemon             = flag.Bool("daemon", false, "Keep running and test on the -delay-cron and -bandwidth-cron schedules, saving every run to -history")
+	delayCron          = flag.String("delay-cron", "*/10 * * * *", "Cron expression of the delay test in -daemon mode, also accepts @hourly, @daily and '@every 10m'; empty disables it")
+	bandwidthCron      = flag.String("bandwidth-cron", "0 */6 * * *", "Cron expression of the bandwidth test in -daemon mode; empty disables it")
+	historyDB          = flag.String("history", "history.db", "History database of -daemon, query it with the 'history' subcommand")
+	serveAPI           = flag.Bool("serve", false, "Keep the nodes loaded and serve an HTTP API, a web dashboard at /ui/ and a mihomo-compatible controller API on -listen to run tests on demand, can be combined with -daemon")
+	secret             = flag.String("secret", "", "Bearer token required by the -serve API, like mihomo's external-controller secret; required when -listen is not a loopback address and enables cross-origin access")
+	listen             = flag.String("listen", "", "Address of the HTTP server in -daemon and -serve mode, e.g. ':9090', serving Prometheus metrics at /metrics and the -serve API at /api/")
+	retention          = flag.Duration("retention", 30*24*time.Hour, "Delete runs older than this from -history; 0 keeps everything")
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "history":
+			runHistory(os.Args[2:])
+			return
+		case "diff":
+			runDiff(os.Args[2:])
+			return
+		case "report":
+			runReport(os.Args[2:])
+			return
+		}
+	}
 	flag.Parse()
+	startTime := time.Now()
 
 	if *configPathConfig == "" {
 		fmt.Fprintln(os.Stderr, "Please specify the configuration file using the -c flag")
@@ -38,7 +117,7 @@ func main() {
 	}
 
 	// Load all proxies
-	allProxies := config.LoadAllProxies(*configPathConfig, *proxy, *forwardProxy)
+	allProxies, sources := config.LoadAllProxiesWithSources(*configPathConfig, *proxy, *forwardProxy)
 	if len(allProxies) == 0 {
 		fmt.Fprintln(os.Stderr, "No proxies found, please check the configuration file")
 		os.Exit(1)
@@ -51,14 +130,277 @@ func main() {
 		os.Exit(1)
 	}
 
+	resolvers, err := outbound.ParseResolvers(*ipLookup, *ipLookupFields, echoURL(*livenessObject))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -ip-lookup: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err := result.ParseSortSpec(*sortField); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -sort: %v\n", err)
+		os.Exit(1)
+	}
+	weights, err := result.ParseScoreWeights(*scoreWeights)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -score-weights: %v\n", err)
+		os.Exit(1)
+	}
+
+	multiplierPatterns, err := nodename.ParseMultiplierPattern(*multiplierPattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -multiplier-regex: %v\n", err)
+		os.Exit(1)
+	}
+
+	pol := parsePolicy()
+
+	var geoDB *geoip.DB
+	if *geoipDB != "" || *asnDB != "" {
+		geoDB, err = geoip.Open(*geoipDB, *asnDB)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer geoDB.Close()
+	}
+
+	opts := tester.Options{
+		SizeMB:         *downloadSizeConfig,
+		Timeout:        *timeoutConfig,
+		Concurrent:     *concurrent,
+		LivenessObject: *livenessObject,
+		DelayTestUrl:   *delayTestUrl,
+		DelayCount:     *delayCount,
+		Resolvers:      resolvers,
+		GeoIP:          geoDB,
+		LoadedLatency:  *bufferbloat,
+	}
+	if *natTest {
+		opts.STUNServer = *stunServer
+	}
+	opts.SweepMaxStreams = *sweep
+	var traffic int64
+	opts.Traffic = &traffic
+	opts.MaxTraffic = int64(*maxTraffic) * 1024 * 1024
+	if *adaptive {
+		if *targetDuration >= *timeoutConfig {
+			fmt.Fprintln(os.Stderr, "-target-duration must be shorter than -timeout")
+			os.Exit(1)
+		}
+		opts.Adaptive = &tester.AdaptiveOptions{
+			ProbeSizeMB:    *probeSize,
+			TargetDuration: *targetDuration,
+		}
+	}
+	if *udpTest {
+		if *udpMode != tester.UDPModeDNS && *udpMode != tester.UDPModeEcho {
+			fmt.Fprintf(os.Stderr, "Unsupported udp mode: %s\n", *udpMode)
+			os.Exit(1)
+		}
+		opts.UDP = &tester.UDPOptions{
+			Target: *udpTarget,
+			Mode:   *udpMode,
+			Count:  *udpCount,
+		}
+	}
+
+	if *rounds > 1 && (*delayTest || *checkpointFile != "") {
+		fmt.Fprintln(os.Stderr, "-rounds cannot be combined with -delay or -checkpoint")
+		os.Exit(1)
+	}
+	if *soakDuration > 0 {
+		if *delayTest || *rounds > 1 || *checkpointFile != "" {
+			fmt.Fprintln(os.Stderr, "-soak cannot be combined with -delay, -rounds or -checkpoint")
+			os.Exit(1)
+		}
+		if *soakMode != tester.SoakModeStream && *soakMode != tester.SoakModeWebSocket {
+			fmt.Fprintf(os.Stderr, "Unsupported soak mode: %s\n", *soakMode)
+			os.Exit(1)
+		}
+		// ws 模式每秒才有一条回显，更短的阈值会把每个间隔都记为卡顿
+		if *soakMode == tester.SoakModeWebSocket && *soakStall <= tester.SoakEchoInterval {
+			fmt.Fprintf(os.Stderr, "-soak-stall must be longer than %s in ws mode\n", tester.SoakEchoInterval)
+			os.Exit(1)
+		}
+		u := *soakURL
+		if u == "" {
+			u = soakTestURL(*livenessObject, *soakMode, *soakRate*1024)
+		}
+		if u == "" {
+			fmt.Fprintln(os.Stderr, "-soak needs -soak-url unless -l points at livenessObject")
+			os.Exit(1)
+		}
+		opts.Soak = &tester.SoakOptions{
+			Duration:       *soakDuration,
+			Mode:           *soakMode,
+			URL:            u,
+			StallThreshold: *soakStall,
+		}
+	}
+
+	var jobs []*daemonJob
+	if *daemon || *serveAPI {
+		if *delayTest || *rounds > 1 || opts.Soak != nil || *checkpointFile != "" {
+			fmt.Fprintln(os.Stderr, "-daemon and -serve cannot be combined with -delay, -rounds, -soak or -checkpoint")
+			os.Exit(1)
+		}
+	}
+	if *serveAPI && *listen == "" {
+		fmt.Fprintln(os.Stderr, "-serve requires -listen")
+		os.Exit(1)
+	}
+	// API 可以提交测试与重新加载配置，监听其他地址时必须设置密钥
+	if *serveAPI && *secret == "" && !isLoopback(*listen) {
+		fmt.Fprintln(os.Stderr, "-serve requires -secret unless -listen is a loopback address")
+		os.Exit(1)
+	}
+	if *daemon {
+		jobs, err = parseJobs(*delayCron, *bandwidthCron)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	// 已完成的结果，来自检查点文件
+	var finished []result.Result
+	if *resume {
+		if *checkpointFile == "" {
+			fmt.Fprintln(os.Stderr, "-resume requires -checkpoint")
+			os.Exit(1)
+		}
+		finished, err = checkpoint.Load(*checkpointFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load checkpoint: %v\n", err)
+			os.Exit(1)
+		}
+		finished = resumeResults(finished, allProxies)
+		fmt.Printf("Resuming from %s, %d nodes already measured\n", *checkpointFile, len(finished))
+	}
+	if *checkpointFile != "" {
+		w, err := checkpoint.Create(*checkpointFile, *resume)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open checkpoint: %v\n", err)
+			os.Exit(1)
+		}
+		defer w.Close()
+		opts.OnResult = func(res result.Result) {
+			if err := w.Append(res); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write checkpoint: %v\n", err)
+			}
+		}
+	}
+
+	// 收到 Ctrl-C 或 SIGTERM 时取消正在进行的测试，仍然输出已完成的结果
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		// 恢复默认行为，再次按下 Ctrl-C 时立即退出
+		signal.Stop(signals)
+		fmt.Fprintln(os.Stderr, "\nInterrupted, writing finished results (press Ctrl-C again to quit immediately)")
+		cancel()
+	}()
+
+	if *daemon || *serveAPI {
+		store, err := history.Open(*historyDB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open history: %v\n", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		rec := &recorder{store: store}
+		mux := http.NewServeMux()
+		if *listen != "" {
+			rec.exporter = metrics.New()
+			mux.Handle("/metrics", rec.exporter)
+		}
+		if *serveAPI {
+			load := func() (map[string]config.CProxy, map[string]string) {
+				return config.LoadAllProxiesWithSources(*configPathConfig, *proxy, *forwardProxy)
+			}
+			run := func(ctx context.Context, kind string, names []string, proxies map[string]config.CProxy, sources map[string]string, opts tester.Options) []result.Result {
+				results, start := runTests(ctx, kind, names, proxies, sources, opts, weights, multiplierPatterns)
+				// 取消的任务结果不完整，不写入历史；指定节点或过滤的任务只更新测试了的节点
+				if ctx.Err() == nil {
+					rec.record(kind, start, results, len(names) < len(proxies))
+				}
+				return results
+			}
+			rec.server = api.New(allProxies, sources, load, run, opts)
+			rec.server.SetTestLock(&testMu)
+			if err := rec.server.SetHistory(store); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to read history: %v\n", err)
+			}
+			apiMux := http.NewServeMux()
+			rec.server.Register(apiMux)
+			rec.server.RegisterController(apiMux, version)
+			mux.Handle("/", api.Protect(*secret, apiMux))
+			// 面板只包含静态文件，数据仍通过需要密钥的 /api/ 获取
+			mux.Handle("/ui/", dashboard.Handler("/ui/"))
+			go rec.server.Run(ctx)
+		}
+		if *listen != "" {
+			if err := serve(*listen, mux); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to start HTTP server: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *daemon {
+			runDaemon(ctx, jobs, rec, opts, weights, multiplierPatterns)
+		} else {
+			<-ctx.Done()
+		}
+		return
+	}
+
+	// 终端中显示实时进度，否则逐行输出
+	if !*delayTest && opts.Soak == nil && !*plainOutput {
+		if ui := progress.New(os.Stdout, os.Stdin, *sortField, cancel); ui != nil {
+			opts.Progress = ui
+		}
+	}
+
 	// Test proxies
 	var results []result.Result
 
 	if *delayTest {
-		results = tester.TestProxiesDelay(allProxies, *delayTestUrl, *timeoutConfig)
-		result.DisplayDelayResult(results)
+		results = tester.TestProxiesDelay(ctx, skipFinished(allProxies, finished), opts)
+	} else if opts.Soak != nil {
+		results = tester.TestProxiesSoak(ctx, filteredProxies, allProxies, opts)
+	} else if *rounds > 1 {
+		results = testRounds(ctx, filteredProxies, allProxies, opts, *rounds, *cooldown)
+	} else {
+		results = tester.TestProxies(ctx, skipFinishedNames(filteredProxies, finished), allProxies, opts)
+	}
+	interrupted := ctx.Err() != nil
+	results = append(finished, results...)
+
+	annotateSources(results, allProxies, sources)
+	nodename.AnnotateRegions(results)
+	nodename.AnnotateMultipliers(results, multiplierPatterns)
+	result.ScoreResults(results, weights)
+	exitClusters, entryClusters := result.AssignClusters(results)
+
+	// 稳定性测试不测带宽与延迟，不适用阈值策略
+	usePolicy := pol.Enabled() && opts.Soak == nil
+	var summary policy.Summary
+	if usePolicy {
+		summary = pol.Evaluate(results)
+	}
+
+	if opts.Soak != nil {
+		result.DisplaySoakResults(results)
+	} else if *delayTest {
+		result.DisplayDelayResult(results, delaySort())
+		result.DisplayClusters(results, exitClusters, entryClusters)
 	} else {
-		results = tester.TestProxies(filteredProxies, allProxies, *downloadSizeConfig, *timeoutConfig, *concurrent, *livenessObject)
+		var pairs []result.PairResult
+		if *pairTest {
+			pairs = tester.TestSharedBandwidth(ctx, results, append(exitClusters, entryClusters...), allProxies, opts)
+		}
 
 		// Sort results
 		if *sortField != "" {
@@ -67,14 +409,212 @@ func main() {
 
 		// Display results
 		result.DisplayResults(results, *sortField)
+		result.DisplayClusters(results, exitClusters, entryClusters)
+		result.DisplayPairResults(pairs)
+	}
+
+	result.DisplayTraffic(results, atomic.LoadInt64(&traffic))
+	if usePolicy {
+		summary.Display(pol)
 	}
 
 	// Output to file
 	if *outputFormat != "" {
-		if err := output.WriteResultsToFile(*outputFormat, *outputFile, results, allProxies); err != nil {
+		mode := "bandwidth"
+		switch {
+		case opts.Soak != nil:
+			mode = "soak"
+		case *delayTest:
+			mode = "delay"
+		case *rounds > 1:
+			mode = "rounds"
+		}
+		if err := output.WriteResultsToFile(*outputFormat, *outputFile, results, allProxies, runMetadata(mode, startTime)); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to write results to file: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Printf("Results have been written to the %s file\n", *outputFormat)
 	}
+
+	if interrupted {
+		os.Exit(130)
+	}
+	// 不满足阈值策略时以非零状态码退出，便于 CI 判断
+	if usePolicy && !summary.OK {
+		os.Exit(2)
+	}
+}
+
+// parsePolicy 由命令行参数生成阈值策略
+func parsePolicy() policy.Policy {
+	return policy.Policy{
+		MinBandwidth:      *minBandwidth * 1024 * 1024,
+		MaxTTFB:           *maxTTFB,
+		MaxDelay:          *maxDelay,
+		MinPassCount:      *minPass,
+		MinPassPercent:    *minPassPercent,
+		RequiredCountries: policy.ParseCountries(*requireCountries),
+	}
+}
+
+// delaySort 返回延迟模式的排序规则：-sort 的默认值按带宽排序，不适用于延迟结果，
+// 只有明确指定 -sort 时才使用，否则返回空字符串按延迟排序
+func delaySort() string {
+	sortBy := ""
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "sort" {
+			sortBy = f.Value.String()
+		}
+	})
+	return sortBy
+}
+
+// runMetadata 返回写入 JSON 结果的运行信息，参数只记录命令行中显式设置的
+func runMetadata(mode string, start time.Time) output.Metadata {
+	host, _ := os.Hostname()
+	params := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "c", "proxy", "forward-proxy":
+			params[f.Name] = redactURLs(f.Value.String())
+		default:
+			params[f.Name] = f.Value.String()
+		}
+	})
+	return output.Metadata{
+		Version:    version,
+		Mode:       mode,
+		StartTime:  start,
+		EndTime:    time.Now(),
+		Host:       host,
+		Parameters: params,
+	}
+}
+
+// redactURLs 去掉逗号分隔的地址中的路径、查询参数与账号，订阅地址中通常带有令牌
+func redactURLs(value string) string {
+	parts := strings.Split(value, ",")
+	for i, part := range parts {
+		u, err := url.Parse(part)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		parts[i] = u.Scheme + "://" + u.Host
+		if u.User != nil || u.Path != "" || u.RawQuery != "" {
+			parts[i] += "/..."
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// testRounds 进行 count 轮带宽测试，每轮随机打乱节点顺序，轮与轮之间暂停 cooldown。
+// 中断时合并已完成的各轮结果。
+func testRounds(ctx context.Context, names []string, proxies map[string]config.CProxy, opts tester.Options, count int, cooldown time.Duration) []result.Result {
+	rounds := make([][]result.Result, 0, count)
+	for round := 1; round <= count; round++ {
+		order := make([]string, len(names))
+		copy(order, names)
+		rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+
+		fmt.Printf("\nRound %d/%d\n", round, count)
+		rounds = append(rounds, tester.TestProxies(ctx, order, proxies, opts))
+		if ctx.Err() != nil || round == count {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(cooldown):
+		}
+	}
+	return result.AggregateRounds(rounds)
+}
+
+// annotateSources 写入节点的协议类型与来自的配置
+func annotateSources(results []result.Result, proxies map[string]config.CProxy, sources map[string]string) {
+	for i := range results {
+		if p, ok := proxies[results[i].Name]; ok {
+			results[i].Type = p.Type().String()
+		}
+		results[i].Source = sources[results[i].Name]
+	}
+}
+
+// resumeResults 只保留检查点中仍存在于当前配置的节点
+func resumeResults(finished []result.Result, proxies map[string]config.CProxy) []result.Result {
+	kept := make([]result.Result, 0, len(finished))
+	for _, res := range finished {
+		if _, ok := proxies[res.Name]; ok {
+			kept = append(kept, res)
+		}
+	}
+	return kept
+}
+
+func finishedNames(finished []result.Result) map[string]bool {
+	names := make(map[string]bool, len(finished))
+	for _, res := range finished {
+		names[res.Name] = true
+	}
+	return names
+}
+
+func skipFinished(proxies map[string]config.CProxy, finished []result.Result) map[string]config.CProxy {
+	if len(finished) == 0 {
+		return proxies
+	}
+	done := finishedNames(finished)
+	remaining := make(map[string]config.CProxy, len(proxies))
+	for name, proxy := range proxies {
+		if !done[name] {
+			remaining[name] = proxy
+		}
+	}
+	return remaining
+}
+
+func skipFinishedNames(names []string, finished []result.Result) []string {
+	if len(finished) == 0 {
+		return names
+	}
+	done := finishedNames(finished)
+	remaining := make([]string, 0, len(names))
+	for _, name := range names {
+		if !done[name] {
+			remaining = append(remaining, name)
+		}
+	}
+	return remaining
+}
+
+// echoURL 由测速地址推导出 livenessObject 的 /ip 地址，Cloudflare 等第三方测速地址返回空
+func echoURL(livenessObject string) string {
+	u, err := url.Parse(livenessObject)
+	if err != nil || u.Path != "/_down" {
+		return ""
+	}
+	u.Path = "/ip"
+	u.RawQuery = ""
+	return u.String()
+}
+
+// soakTestURL 由测速地址推导出 livenessObject 的慢速下载或 websocket 回显地址，第三方测速地址返回空
+func soakTestURL(livenessObject string, mode string, rate int) string {
+	u, err := url.Parse(livenessObject)
+	if err != nil || u.Path != "/_down" {
+		return ""
+	}
+	if mode == tester.SoakModeWebSocket {
+		u.Path = "/ws"
+		u.RawQuery = ""
+		if u.Scheme == "https" {
+			u.Scheme = "wss"
+		} else {
+			u.Scheme = "ws"
+		}
+		return u.String()
+	}
+	u.Path = "/_slow"
+	u.RawQuery = fmt.Sprintf("rate=%d", rate)
+	return u.String()
 }
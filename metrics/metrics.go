@@ -0,0 +1,244 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+// durationBuckets 为运行耗时直方图的上界（秒）
+var durationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets))
+	}
+	for i, le := range durationBuckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// run 为一类测试最近一次的结果与累计的运行统计
+type run struct {
+	// times 为每个节点最近一次测试完成的时间
+	times    map[string]time.Time
+	results  []result.Result
+	runs     uint64
+	tested   uint64
+	failed   uint64
+	duration histogram
+}
+
+// Exporter 以 Prometheus 文本格式导出每类测试最近一次的节点结果与累计的运行统计
+type Exporter struct {
+	mu   sync.Mutex
+	runs map[string]*run
+}
+
+func New() *Exporter {
+	return &Exporter{runs: make(map[string]*run)}
+}
+
+// Observe 记录一次 kind 类型（delay 或 bandwidth）测试的结果。完整的运行替换该类测试之前的节点结果，
+// 已从订阅中删除的节点不再导出；partial 为 true 时只更新测试了的节点
+func (e *Exporter) Observe(kind string, start time.Time, duration time.Duration, results []result.Result, partial bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	r, ok := e.runs[kind]
+	if !ok {
+		r = &run{times: make(map[string]time.Time)}
+		e.runs[kind] = r
+	}
+	if partial {
+		r.results = result.MergeByName(r.results, results)
+	} else {
+		r.results = results
+		r.times = make(map[string]time.Time, len(results))
+	}
+	for _, res := range results {
+		r.times[res.Name] = start.Add(duration)
+	}
+	r.runs++
+	r.duration.observe(duration.Seconds())
+	for _, res := range results {
+		if res.Skipped {
+			continue
+		}
+		r.tested++
+		if !succeeded(kind, res) {
+			r.failed++
+		}
+	}
+}
+
+func delayOK(res result.Result) bool {
+	return res.Delay > 0 && res.Delay != 9999
+}
+
+func succeeded(kind string, res result.Result) bool {
+	if kind == "delay" {
+		return delayOK(res)
+	}
+	return res.Bandwidth > 0
+}
+
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	e.write(bw)
+	bw.Flush()
+}
+
+func (e *Exporter) write(w *bufio.Writer) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	kinds := make([]string, 0, len(e.runs))
+	for kind := range e.runs {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	header(w, "speedtest_node_bandwidth_bytes_per_second", "gauge", "Download bandwidth of the node in the latest bandwidth test.")
+	eachNode(e.runs["bandwidth"], func(res result.Result, labels string) {
+		if res.Bandwidth > 0 {
+			sample(w, "speedtest_node_bandwidth_bytes_per_second", labels, res.Bandwidth)
+		}
+	})
+	header(w, "speedtest_node_ttfb_seconds", "gauge", "Time to first byte of the node in the latest bandwidth test.")
+	eachNode(e.runs["bandwidth"], func(res result.Result, labels string) {
+		if res.TTFB > 0 {
+			sample(w, "speedtest_node_ttfb_seconds", labels, res.TTFB.Seconds())
+		}
+	})
+	header(w, "speedtest_node_delay_seconds", "gauge", "Delay of the node in the latest delay test.")
+	eachNode(e.runs["delay"], func(res result.Result, labels string) {
+		if delayOK(res) {
+			sample(w, "speedtest_node_delay_seconds", labels, float64(res.Delay)/1000)
+		}
+	})
+
+	header(w, "speedtest_node_success", "gauge", "Whether the node worked in the latest test of each kind.")
+	for _, kind := range kinds {
+		eachNode(e.runs[kind], func(res result.Result, labels string) {
+			v := 0.0
+			if succeeded(kind, res) {
+				v = 1
+			}
+			sample(w, "speedtest_node_success", labels+`,test="`+kind+`"`, v)
+		})
+	}
+	header(w, "speedtest_node_last_test_timestamp_seconds", "gauge", "Unix time the latest test of each kind finished.")
+	for _, kind := range kinds {
+		r := e.runs[kind]
+		eachNode(r, func(res result.Result, labels string) {
+			sample(w, "speedtest_node_last_test_timestamp_seconds", labels+`,test="`+kind+`"`, float64(r.times[res.Name].Unix()))
+		})
+	}
+
+	header(w, "speedtest_node_info", "gauge", "Node metadata; join on name to break the value metrics down by country.")
+	for _, res := range nodeInfo(e.runs, kinds) {
+		labels := nodeLabels(res) + `,country="` + escape(res.Country) + `"`
+		sample(w, "speedtest_node_info", labels, 1)
+	}
+
+	header(w, "speedtest_runs_total", "counter", "Number of finished test runs.")
+	for _, kind := range kinds {
+		sample(w, "speedtest_runs_total", `test="`+kind+`"`, float64(e.runs[kind].runs))
+	}
+	header(w, "speedtest_nodes_tested_total", "counter", "Number of node tests across all runs.")
+	for _, kind := range kinds {
+		sample(w, "speedtest_nodes_tested_total", `test="`+kind+`"`, float64(e.runs[kind].tested))
+	}
+	header(w, "speedtest_nodes_failed_total", "counter", "Number of failed node tests across all runs.")
+	for _, kind := range kinds {
+		sample(w, "speedtest_nodes_failed_total", `test="`+kind+`"`, float64(e.runs[kind].failed))
+	}
+
+	header(w, "speedtest_run_duration_seconds", "histogram", "Duration of test runs.")
+	for _, kind := range kinds {
+		h := e.runs[kind].duration
+		for i, le := range durationBuckets {
+			sample(w, "speedtest_run_duration_seconds_bucket", `test="`+kind+`",le="`+formatFloat(le)+`"`, float64(h.counts[i]))
+		}
+		sample(w, "speedtest_run_duration_seconds_bucket", `test="`+kind+`",le="+Inf"`, float64(h.count))
+		sample(w, "speedtest_run_duration_seconds_sum", `test="`+kind+`"`, h.sum)
+		sample(w, "speedtest_run_duration_seconds_count", `test="`+kind+`"`, float64(h.count))
+	}
+}
+
+// eachNode 按名称顺序遍历未被跳过的节点，labels 为节点的标签
+func eachNode(r *run, fn func(res result.Result, labels string)) {
+	if r == nil {
+		return
+	}
+	results := make([]result.Result, 0, len(r.results))
+	for _, res := range r.results {
+		if !res.Skipped {
+			results = append(results, res)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	for _, res := range results {
+		fn(res, nodeLabels(res))
+	}
+}
+
+// nodeLabels 返回节点的标签。出口国家可能在两次测试之间变化，只在 speedtest_node_info 中导出，
+// 避免数值指标因此产生新的时间序列
+func nodeLabels(res result.Result) string {
+	return fmt.Sprintf(`name="%s",type="%s",source="%s"`, escape(res.Name), escape(res.Type), escape(res.Source))
+}
+
+// nodeInfo 返回各类测试中出现过的节点，每个名称一个，优先取带有出口国家的结果
+func nodeInfo(runs map[string]*run, kinds []string) []result.Result {
+	byName := make(map[string]result.Result)
+	for _, kind := range kinds {
+		eachNode(runs[kind], func(res result.Result, _ string) {
+			if old, ok := byName[res.Name]; !ok || old.Country == "" {
+				byName[res.Name] = res
+			}
+		})
+	}
+	nodes := make([]result.Result, 0, len(byName))
+	for _, res := range byName {
+		nodes = append(nodes, res)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+	return nodes
+}
+
+func header(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func sample(w *bufio.Writer, name, labels string, v float64) {
+	fmt.Fprintf(w, "%s{%s} %s\n", name, labels, formatFloat(v))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string {
+	return labelEscaper.Replace(s)
+}
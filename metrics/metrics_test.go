@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+func TestExporter(t *testing.T) {
+	e := New()
+	start := time.Unix(1700000000, 0)
+	e.Observe("bandwidth", start, 20*time.Second, []result.Result{
+		{Name: `HK "01"`, Type: "Shadowsocks", Source: "sub.example.com", Country: "HK", Bandwidth: 1048576, TTFB: 150 * time.Millisecond},
+		{Name: "JP 01", Type: "Vmess", Source: "sub.example.com", Country: "JP"},
+		{Name: "US 01", Skipped: true},
+	}, false)
+	e.Observe("delay", start, 2*time.Second, []result.Result{{Name: "JP 01", Delay: 9999}}, false)
+	e.Observe("delay", start.Add(time.Minute), 3*time.Second, []result.Result{{Name: "JP 01", Delay: 80}}, false)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	out := string(body)
+
+	for _, line := range []string{
+		`speedtest_node_bandwidth_bytes_per_second{name="HK \"01\"",type="Shadowsocks",source="sub.example.com"} 1048576`,
+		`speedtest_node_ttfb_seconds{name="HK \"01\"",type="Shadowsocks",source="sub.example.com"} 0.15`,
+		`speedtest_node_delay_seconds{name="JP 01",type="",source=""} 0.08`,
+		`speedtest_node_success{name="JP 01",type="Vmess",source="sub.example.com",test="bandwidth"} 0`,
+		`speedtest_node_last_test_timestamp_seconds{name="JP 01",type="",source="",test="delay"} 1700000063`,
+		`speedtest_node_info{name="HK \"01\"",type="Shadowsocks",source="sub.example.com",country="HK"} 1`,
+		`speedtest_node_info{name="JP 01",type="Vmess",source="sub.example.com",country="JP"} 1`,
+		`speedtest_runs_total{test="delay"} 2`,
+		`speedtest_nodes_failed_total{test="delay"} 1`,
+		`speedtest_nodes_tested_total{test="bandwidth"} 2`,
+		`speedtest_run_duration_seconds_bucket{test="delay",le="1"} 0`,
+		`speedtest_run_duration_seconds_bucket{test="delay",le="5"} 2`,
+		`speedtest_run_duration_seconds_bucket{test="bandwidth",le="+Inf"} 1`,
+		`speedtest_run_duration_seconds_sum{test="delay"} 5`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+	if strings.Contains(out, "US 01") {
+		t.Error("skipped node exported")
+	}
+	// 只测试部分节点的运行不删除其他节点
+	e.Observe("bandwidth", start.Add(time.Hour), 10*time.Second, []result.Result{
+		{Name: "JP 01", Type: "Vmess", Source: "sub.example.com", Country: "JP", Bandwidth: 2097152},
+	}, true)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ = io.ReadAll(rec.Body)
+	partial := string(body)
+	for _, line := range []string{
+		`speedtest_node_bandwidth_bytes_per_second{name="HK \"01\"",type="Shadowsocks",source="sub.example.com"} 1048576`,
+		`speedtest_node_bandwidth_bytes_per_second{name="JP 01",type="Vmess",source="sub.example.com"} 2097152`,
+		`speedtest_node_last_test_timestamp_seconds{name="HK \"01\"",type="Shadowsocks",source="sub.example.com",test="bandwidth"} 1700000020`,
+		`speedtest_node_last_test_timestamp_seconds{name="JP 01",type="Vmess",source="sub.example.com",test="bandwidth"} 1700003610`,
+	} {
+		if !strings.Contains(partial, line+"\n") {
+			t.Errorf("after partial run: missing %s", line)
+		}
+	}
+
+	// 出口国家只作为 speedtest_node_info 的标签
+	if n := strings.Count(out, "country="); n != 2 {
+		t.Errorf("country label on %d samples, want 2", n)
+	}
+}
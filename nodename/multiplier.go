@@ -0,0 +1,58 @@
+package nodename
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+// DefaultMultiplierPatterns 识别 “倍率0.5”、“x2”、“×1.5”、“[3x]”、“2倍” 等写法，
+// 每个表达式中第一个非空的分组为倍率
+var DefaultMultiplierPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`倍率\s*[:：]?\s*(\d+(?:\.\d+)?)`),
+	regexp.MustCompile(`(?i)(?:^|[^a-z0-9])[x×]\s*(\d+(?:\.\d+)?)(?:[^\d.]|$)`),
+	regexp.MustCompile(`(?i)(\d+(?:\.\d+)?)\s*(?:[x×]|倍)(?:[^a-z]|$)`),
+}
+
+// ParseMultiplierPattern 编译用户自定义的倍率表达式，表达式中至少需要一个分组
+func ParseMultiplierPattern(pattern string) ([]*regexp.Regexp, error) {
+	if pattern == "" {
+		return DefaultMultiplierPatterns, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if re.NumSubexp() == 0 {
+		return nil, fmt.Errorf("multiplier pattern must contain a capture group: %s", pattern)
+	}
+	return []*regexp.Regexp{re}, nil
+}
+
+// Multiplier 从节点名称中解析流量倍率，未标注时为 1
+func Multiplier(name string, patterns []*regexp.Regexp) float64 {
+	for _, re := range patterns {
+		match := re.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		for _, group := range match[1:] {
+			if group == "" {
+				continue
+			}
+			if v, err := strconv.ParseFloat(group, 64); err == nil {
+				return v
+			}
+		}
+	}
+	return 1
+}
+
+// AnnotateMultipliers 为结果写入节点名称中的流量倍率
+func AnnotateMultipliers(results []result.Result, patterns []*regexp.Regexp) {
+	for i := range results {
+		results[i].Multiplier = Multiplier(results[i].Name, patterns)
+	}
+}
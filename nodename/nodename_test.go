@@ -0,0 +1,84 @@
+package nodename
+
+import "testing"
+
+func TestRegion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "🇭🇰 香港 01", expected: "HK"},
+		{input: "🇯🇵 Japan | IPLC", expected: "JP"},
+		{input: "香港 IEPL 02", expected: "HK"},
+		{input: "Premium|广港|IEPL|01", expected: "HK"},
+		{input: "台灣 HiNet", expected: "TW"},
+		{input: "Tokyo-01", expected: "JP"},
+		{input: "US-LA 1Gbps", expected: "US"},
+		{input: "HK01", expected: "HK"},
+		{input: "印度尼西亚 01", expected: "ID"},
+		{input: "CN2 GIA 美国", expected: "US"},
+		{input: "CN2 GIA 02", expected: ""},
+		{input: "Us Hub", expected: ""},
+		{input: "剩余流量：100GB", expected: ""},
+		// 中转节点按落地地区识别，入口城市不代表声明的地区
+		{input: "上海→日本 IEPL", expected: "JP"},
+		{input: "广州-香港 IPLC 01", expected: "HK"},
+		{input: "深圳 - 台湾 专线", expected: "TW"},
+		{input: "中国 → 新加坡 IPLC", expected: "SG"},
+		{input: "北京联通 中转", expected: ""},
+		{input: "回国 中国电信", expected: "CN"},
+		// 多个地名时取最先出现的
+		{input: "日本 via 香港", expected: "JP"},
+		{input: "Singapore - Tokyo", expected: "SG"},
+	}
+
+	for _, test := range tests {
+		if result := Region(test.input); result != test.expected {
+			t.Errorf("Region(%q) = %q; want %q", test.input, result, test.expected)
+		}
+	}
+}
+
+func TestCheckRegion(t *testing.T) {
+	if !CheckRegion("HK", "JP") {
+		t.Errorf("CheckRegion(HK, JP) should report a mismatch")
+	}
+	if CheckRegion("HK", "hk") || CheckRegion("", "JP") || CheckRegion("HK", "") {
+		t.Errorf("CheckRegion should only report known, different regions")
+	}
+}
+
+func TestMultiplier(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{input: "香港 01 x2", expected: 2},
+		{input: "日本 倍率0.5", expected: 0.5},
+		{input: "[3x] 美国", expected: 3},
+		{input: "新加坡 ×1.5", expected: 1.5},
+		{input: "台湾 2倍", expected: 2},
+		{input: "香港 IEPL 01", expected: 1},
+		{input: "Linux2 Hub", expected: 1},
+		{input: "Xray 01", expected: 1},
+		{input: "免流 倍率0", expected: 0},
+		{input: "香港 0x", expected: 0},
+	}
+
+	for _, test := range tests {
+		if result := Multiplier(test.input, DefaultMultiplierPatterns); result != test.expected {
+			t.Errorf("Multiplier(%q) = %v; want %v", test.input, result, test.expected)
+		}
+	}
+
+	patterns, err := ParseMultiplierPattern(`rate=(\d+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result := Multiplier("HK rate=4 x2", patterns); result != 4 {
+		t.Errorf("custom pattern Multiplier = %v; want 4", result)
+	}
+	if _, err := ParseMultiplierPattern(`x\d`); err == nil {
+		t.Errorf("ParseMultiplierPattern without a group should fail")
+	}
+}
@@ -0,0 +1,165 @@
+package nodename
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+// regionNames 将常见的中英文国家、地区与城市名称映射到 ISO 3166-1 alpha-2 代码
+var regionNames = map[string]string{
+	"香港": "HK", "hongkong": "HK", "hong kong": "HK", "港": "HK",
+	"台湾": "TW", "台灣": "TW", "臺灣": "TW", "taiwan": "TW", "台北": "TW", "taipei": "TW", "新北": "TW", "彰化": "TW",
+	"澳门": "MO", "澳門": "MO", "macau": "MO", "macao": "MO",
+	"日本": "JP", "japan": "JP", "东京": "JP", "東京": "JP", "tokyo": "JP", "大阪": "JP", "osaka": "JP", "埼玉": "JP",
+	"新加坡": "SG", "狮城": "SG", "獅城": "SG", "singapore": "SG",
+	"美国": "US", "美國": "US", "united states": "US", "america": "US", "洛杉矶": "US", "los angeles": "US",
+	"圣何塞": "US", "san jose": "US", "西雅图": "US", "seattle": "US", "硅谷": "US", "silicon valley": "US",
+	"纽约": "US", "new york": "US", "芝加哥": "US", "chicago": "US", "达拉斯": "US", "dallas": "US",
+	"凤凰城": "US", "phoenix": "US", "弗里蒙特": "US", "fremont": "US", "迈阿密": "US", "miami": "US",
+	"韩国": "KR", "韓國": "KR", "korea": "KR", "首尔": "KR", "首爾": "KR", "seoul": "KR", "春川": "KR",
+	"英国": "GB", "英國": "GB", "united kingdom": "GB", "伦敦": "GB", "london": "GB",
+	"德国": "DE", "德國": "DE", "germany": "DE", "法兰克福": "DE", "frankfurt": "DE",
+	"法国": "FR", "法國": "FR", "france": "FR", "巴黎": "FR", "paris": "FR",
+	"荷兰": "NL", "荷蘭": "NL", "netherlands": "NL", "阿姆斯特丹": "NL", "amsterdam": "NL",
+	"俄罗斯": "RU", "俄羅斯": "RU", "russia": "RU", "莫斯科": "RU", "moscow": "RU",
+	"加拿大": "CA", "canada": "CA", "多伦多": "CA", "toronto": "CA", "温哥华": "CA", "vancouver": "CA",
+	"澳大利亚": "AU", "澳洲": "AU", "australia": "AU", "悉尼": "AU", "sydney": "AU",
+	"印度": "IN", "india": "IN", "孟买": "IN", "mumbai": "IN",
+	"土耳其": "TR", "turkey": "TR", "伊斯坦布尔": "TR", "istanbul": "TR",
+	"泰国": "TH", "泰國": "TH", "thailand": "TH", "曼谷": "TH", "bangkok": "TH",
+	"越南": "VN", "vietnam": "VN",
+	"马来西亚": "MY", "馬來西亞": "MY", "malaysia": "MY",
+	"菲律宾": "PH", "菲律賓": "PH", "philippines": "PH",
+	"印尼": "ID", "印度尼西亚": "ID", "indonesia": "ID",
+	"阿根廷": "AR", "argentina": "AR",
+	"巴西": "BR", "brazil": "BR",
+	"意大利": "IT", "italy": "IT", "米兰": "IT", "milan": "IT",
+	"西班牙": "ES", "spain": "ES",
+	"瑞士": "CH", "switzerland": "CH",
+	"瑞典": "SE", "sweden": "SE",
+	"爱尔兰": "IE", "ireland": "IE",
+	"乌克兰": "UA", "ukraine": "UA",
+	"以色列": "IL", "israel": "IL",
+	"阿联酋": "AE", "迪拜": "AE", "dubai": "AE",
+	"南非": "ZA", "south africa": "ZA",
+	"尼日利亚": "NG", "nigeria": "NG",
+	"墨西哥": "MX", "mexico": "MX",
+	"智利": "CL", "chile": "CL",
+	// 上海、广州等国内城市通常是中转入口（如“上海→日本 IEPL”），不代表声明的地区，不在此列出
+	"中国": "CN", "中國": "CN", "china": "CN", "回国": "CN",
+}
+
+// regionCodes 为节点名称中作为独立单词出现时可识别的代码，包括部分三位代码
+var regionCodes = map[string]string{
+	"HK": "HK", "HKG": "HK", "TW": "TW", "TWN": "TW", "MO": "MO", "JP": "JP", "JPN": "JP",
+	"SG": "SG", "SGP": "SG", "US": "US", "USA": "US", "KR": "KR", "KOR": "KR",
+	"UK": "GB", "GB": "GB", "GBR": "GB", "DE": "DE", "DEU": "DE", "FR": "FR", "NL": "NL",
+	"RU": "RU", "CA": "CA", "AU": "AU", "IN": "IN", "TR": "TR", "TH": "TH", "VN": "VN",
+	"MY": "MY", "PH": "PH", "ID": "ID", "AR": "AR", "BR": "BR", "IT": "IT", "ES": "ES",
+	"CH": "CH", "SE": "SE", "IE": "IE", "UA": "UA", "IL": "IL", "AE": "AE", "ZA": "ZA",
+	"NG": "NG", "MX": "MX", "CL": "CL", "CN": "CN",
+}
+
+// sortedRegionNames 按长度降序排列，同一位置优先匹配更长的名称（如“印度尼西亚”优先于“印度”）
+var sortedRegionNames = func() []string {
+	names := make([]string, 0, len(regionNames))
+	for name := range regionNames {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+		return names[i] < names[j]
+	})
+	return names
+}()
+
+// lineNames 为线路名称，其中的 CN2 不代表地区
+var lineNames = regexp.MustCompile(`(?i)cn2`)
+
+var words = regexp.MustCompile(`[A-Za-z]+`)
+
+// Region 从节点名称中解析声明的地区，返回 ISO 3166-1 alpha-2 代码，无法识别时返回空字符串。
+// 依次识别国旗 emoji、中英文地名和独立的大写地区代码。
+// 名称中有多个地名时取最先出现的一个；中国只在没有其他地名时使用，
+// 因为“中国→日本”之类的名称中它是中转入口而不是落地地区。
+func Region(name string) string {
+	if code := flagRegion(name); code != "" {
+		return code
+	}
+
+	lower := strings.ToLower(name)
+	code, pos, cn := "", -1, false
+	for _, n := range sortedRegionNames {
+		i := strings.Index(lower, n)
+		if i < 0 {
+			continue
+		}
+		if regionNames[n] == "CN" {
+			cn = true
+			continue
+		}
+		// 按长度降序遍历，同一位置保留更长的名称
+		if pos < 0 || i < pos {
+			code, pos = regionNames[n], i
+		}
+	}
+	if code != "" {
+		return code
+	}
+	if cn {
+		return "CN"
+	}
+
+	name = lineNames.ReplaceAllString(name, " ")
+	for _, loc := range words.FindAllStringIndex(name, -1) {
+		word := name[loc[0]:loc[1]]
+		// 只接受全大写的代码，避免把普通单词识别为地区
+		if word != strings.ToUpper(word) {
+			continue
+		}
+		// 紧跟在数字后的是单位，如 100GB
+		if loc[0] > 0 && name[loc[0]-1] >= '0' && name[loc[0]-1] <= '9' {
+			continue
+		}
+		if code, ok := regionCodes[word]; ok {
+			return code
+		}
+	}
+	return ""
+}
+
+// flagRegion 将一对区域指示符号（国旗 emoji）转换为地区代码
+func flagRegion(name string) string {
+	runes := []rune(name)
+	for i := 0; i+1 < len(runes); i++ {
+		if isRegionalIndicator(runes[i]) && isRegionalIndicator(runes[i+1]) {
+			return string([]rune{runes[i] - 0x1F1E6 + 'A', runes[i+1] - 0x1F1E6 + 'A'})
+		}
+	}
+	return ""
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// CheckRegion 比较声明的地区与检测到的出口国家，两者都已知且不同时返回 true
+func CheckRegion(claimed string, country string) bool {
+	if claimed == "" || country == "" {
+		return false
+	}
+	return !strings.EqualFold(claimed, country)
+}
+
+// AnnotateRegions 为结果写入节点名称声明的地区，并标记与出口国家不一致的节点
+func AnnotateRegions(results []result.Result) {
+	for i := range results {
+		results[i].ClaimedRegion = Region(results[i].Name)
+		results[i].RegionMismatch = CheckRegion(results[i].ClaimedRegion, results[i].Country)
+	}
+}
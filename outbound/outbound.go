@@ -0,0 +1,222 @@
+package outbound
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Info 为出口 IP 查询的结果
+type Info struct {
+	IP      string
+	Country string
+	City    string
+	Org     string
+}
+
+// Resolver 通过给定的 HTTP 客户端（通常经由代理）查询出口 IP
+type Resolver interface {
+	Name() string
+	Resolve(ctx context.Context, client *http.Client) (Info, error)
+}
+
+const (
+	CloudflareTraceURL = "https://speed.cloudflare.com/cdn-cgi/trace"
+	IPInfoURL          = "https://ipinfo.io/json"
+)
+
+// Cloudflare 解析 /cdn-cgi/trace 返回的 key=value 文本
+type Cloudflare struct {
+	URL string
+}
+
+func (r *Cloudflare) Name() string { return "cloudflare" }
+
+func (r *Cloudflare) Resolve(ctx context.Context, client *http.Client) (Info, error) {
+	body, err := get(ctx, client, r.URL)
+	if err != nil {
+		return Info{}, err
+	}
+
+	info := Info{}
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "ip":
+			info.IP = value
+		case "loc":
+			info.Country = value
+		}
+	}
+	return info, validate(info)
+}
+
+// JSON 解析 ipinfo 风格的 JSON 接口，Fields 将 ip、country、city、org
+// 映射到响应中的字段，字段路径支持以 . 分隔的嵌套字段
+type JSON struct {
+	URL    string
+	Fields map[string]string
+}
+
+func (r *JSON) Name() string { return "ipinfo" }
+
+func (r *JSON) Resolve(ctx context.Context, client *http.Client) (Info, error) {
+	body, err := get(ctx, client, r.URL)
+	if err != nil {
+		return Info{}, err
+	}
+
+	var data map[string]any
+	if err := json.Unmarshal(body, &data); err != nil {
+		return Info{}, err
+	}
+	info := Info{
+		IP:      lookupField(data, r.Fields["ip"]),
+		Country: lookupField(data, r.Fields["country"]),
+		City:    lookupField(data, r.Fields["city"]),
+		Org:     lookupField(data, r.Fields["org"]),
+	}
+	return info, validate(info)
+}
+
+func lookupField(data map[string]any, path string) string {
+	if path == "" {
+		return ""
+	}
+	var value any = data
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]any)
+		if !ok {
+			return ""
+		}
+		value = m[key]
+	}
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// Echo 读取 livenessObject /ip 接口返回的纯文本地址，不提供国家信息
+type Echo struct {
+	URL string
+}
+
+func (r *Echo) Name() string { return "echo" }
+
+func (r *Echo) Resolve(ctx context.Context, client *http.Client) (Info, error) {
+	body, err := get(ctx, client, r.URL)
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{IP: strings.TrimSpace(string(body))}
+	return info, validate(info)
+}
+
+// Resolve 按顺序尝试各个 Resolver，返回第一个成功的结果。
+// 每个 Resolver 单独计算 timeout，无响应的接口不会占用后续接口的时间
+func Resolve(ctx context.Context, client *http.Client, resolvers []Resolver, timeout time.Duration) (Info, error) {
+	errs := make([]string, 0, len(resolvers))
+	for _, r := range resolvers {
+		rctx, cancel := context.WithTimeout(ctx, timeout)
+		info, err := r.Resolve(rctx, client)
+		cancel()
+		if err == nil {
+			return info, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", r.Name(), err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return Info{}, errors.New(strings.Join(errs, "; "))
+}
+
+// ParseResolvers 解析逗号分隔的 Resolver 列表，例如
+// "cloudflare,ipinfo=https://ipinfo.io/json,echo"。
+// fields 为 JSON Resolver 的字段映射，例如 "ip=query,country=countryCode,org=isp"；
+// echoURL 为未指定地址时 echo 使用的默认地址。
+// 传入 "none" 或空字符串时返回 nil，即关闭出口 IP 查询。
+func ParseResolvers(spec string, fields string, echoURL string) ([]Resolver, error) {
+	if spec == "" || spec == "none" {
+		return nil, nil
+	}
+
+	fieldMap := map[string]string{"ip": "ip", "country": "country", "city": "city", "org": "org"}
+	if fields != "" {
+		for _, pair := range strings.Split(fields, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid field mapping: %s", pair)
+			}
+			if _, known := fieldMap[key]; !known {
+				return nil, fmt.Errorf("unknown field: %s", key)
+			}
+			fieldMap[key] = value
+		}
+	}
+
+	resolvers := make([]Resolver, 0)
+	for _, item := range strings.Split(spec, ",") {
+		name, url, _ := strings.Cut(strings.TrimSpace(item), "=")
+		switch name {
+		case "cloudflare":
+			if url == "" {
+				url = CloudflareTraceURL
+			}
+			resolvers = append(resolvers, &Cloudflare{URL: url})
+		case "ipinfo":
+			if url == "" {
+				url = IPInfoURL
+			}
+			resolvers = append(resolvers, &JSON{URL: url, Fields: fieldMap})
+		case "echo":
+			if url == "" {
+				url = echoURL
+			}
+			if url == "" {
+				return nil, errors.New("echo resolver requires an url")
+			}
+			resolvers = append(resolvers, &Echo{URL: url})
+		default:
+			return nil, fmt.Errorf("unknown ip resolver: %s", name)
+		}
+	}
+	return resolvers, nil
+}
+
+func get(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	// 限制读取大小，查询接口的响应都很小
+	return io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+}
+
+func validate(info Info) error {
+	if net.ParseIP(info.IP) == nil {
+		return fmt.Errorf("invalid ip: %q", info.IP)
+	}
+	return nil
+}
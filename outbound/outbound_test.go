@@ -0,0 +1,84 @@
+package outbound
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseResolvers(t *testing.T) {
+	resolvers, err := ParseResolvers("cloudflare,ipinfo=http://example.com/json,echo", "ip=query,country=geo.cc", "http://127.0.0.1:8080/ip")
+	if err != nil {
+		t.Fatalf("ParseResolvers error: %v", err)
+	}
+	names := []string{"cloudflare", "ipinfo", "echo"}
+	if len(resolvers) != len(names) {
+		t.Fatalf("got %d resolvers; want %d", len(resolvers), len(names))
+	}
+	for i, r := range resolvers {
+		if r.Name() != names[i] {
+			t.Errorf("resolvers[%d] = %s; want %s", i, r.Name(), names[i])
+		}
+	}
+	if j := resolvers[1].(*JSON); j.URL != "http://example.com/json" || j.Fields["ip"] != "query" || j.Fields["city"] != "city" {
+		t.Errorf("ipinfo resolver = %+v", j)
+	}
+
+	if resolvers, err := ParseResolvers("none", "", ""); err != nil || resolvers != nil {
+		t.Errorf("ParseResolvers(none) = %v, %v; want nil, nil", resolvers, err)
+	}
+	for _, spec := range []string{"unknown", "echo"} {
+		if _, err := ParseResolvers(spec, "", ""); err == nil {
+			t.Errorf("ParseResolvers(%q) should fail", spec)
+		}
+	}
+}
+
+func TestResolveFallback(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/trace", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"query":"203.0.113.7","geo":{"cc":"JP"},"org":"AS64500 Example"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resolvers, err := ParseResolvers("cloudflare="+server.URL+"/trace,ipinfo="+server.URL+"/json", "ip=query,country=geo.cc", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := Resolve(context.Background(), server.Client(), resolvers, time.Second)
+	if err != nil {
+		t.Fatalf("Resolve error: %v", err)
+	}
+	want := Info{IP: "203.0.113.7", Country: "JP", Org: "AS64500 Example"}
+	if info != want {
+		t.Errorf("Resolve() = %+v; want %+v", info, want)
+	}
+}
+
+func TestResolveFallbackAfterTimeout(t *testing.T) {
+	mux := http.NewServeMux()
+	// 被封锁的接口通常不返回错误，而是一直无响应
+	mux.HandleFunc("/trace", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/ip", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("203.0.113.8\n"))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resolvers, err := ParseResolvers("cloudflare="+server.URL+"/trace,echo="+server.URL+"/ip", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := Resolve(context.Background(), server.Client(), resolvers, 200*time.Millisecond)
+	if err != nil || info.IP != "203.0.113.8" {
+		t.Errorf("Resolve() = %+v, %v; want the echo result", info, err)
+	}
+}
@@ -6,16 +6,18 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/0x10240/mihomo-speedtest/config"
 	"github.com/0x10240/mihomo-speedtest/result"
 	"gopkg.in/yaml.v3"
 )
 
-func WriteResultsToFile(format string, filePath string, results []result.Result, proxies map[string]config.CProxy) error {
+// WriteResultsToFile 按格式写出结果，meta 仅写入 JSON
+func WriteResultsToFile(format string, filePath string, results []result.Result, proxies map[string]config.CProxy, meta Metadata) error {
 	switch format {
 	case "json":
-		return writeResultsToJson(filePath, results)
+		return writeResultsToJson(filePath, Report{Schema: SchemaVersion, Meta: meta, Results: results})
 	case "yaml":
 		return writeResultsToYAML(filePath, results, proxies)
 	case "csv":
@@ -25,8 +27,8 @@ func WriteResultsToFile(format string, filePath string, results []result.Result,
 	}
 }
 
-// writeResultsToJson writes a slice of Result structs to a JSON file at the specified file path.
-func writeResultsToJson(filePath string, results []result.Result) error {
+// writeResultsToJson writes the report with its results and run metadata to a JSON file at the specified file path.
+func writeResultsToJson(filePath string, report Report) error {
 	// Create or overwrite the specified JSON file
 	file, err := os.Create(filePath)
 	if err != nil {
@@ -35,7 +37,7 @@ func writeResultsToJson(filePath string, results []result.Result) error {
 	defer file.Close()
 
 	// Convert the results to JSON format with indentation
-	data, err := json.MarshalIndent(results, "", "  ")
+	data, err := json.MarshalIndent(report, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -81,16 +83,92 @@ func writeResultsToCSV(filePath string, results []result.Result) error {
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
 
-	writer.Write([]string{"Node", "Bandwidth (MB/s)", "Latency (ms)"})
+	writer.Write([]string{"Node", "Bandwidth (MB/s)", "Latency (ms)", "Delay (ms)", "Jitter (ms)", "Success Rate (%)", "Failed Streams", "Rounds", "Bandwidth Median (MB/s)", "Bandwidth StdDev (MB/s)", "Bandwidth CI95 (MB/s)", "TTFB Median (ms)", "TTFB StdDev (ms)", "TTFB CI95 (ms)", "Round Bandwidths (MB/s)", "Round TTFBs (ms)",
+		"Single-stream Bandwidth (MB/s)", "Best Streams", "Idle Latency (ms)", "Loaded Latency (ms)", "Bufferbloat (ms)", "Test Size (MB)", "Soak Uptime (%)", "Soak Stalls", "Soak Resets", "Soak Reconnects", "Traffic (MB)", "Skipped", "Score", "Status", "Fail Reasons", "IP", "Country", "Claimed Region", "Region Mismatch", "Multiplier", "Cost-adjusted Bandwidth (MB/s)", "City", "ASN", "Org", "Exit Type",
+		"Server", "Type", "Source", "Entry IP", "Entry Country", "Entry ASN", "Entry Org", "Exit Cluster", "Entry Cluster", "UDP", "UDP RTT (ms)", "UDP Loss (%)", "NAT Type"})
 
 	for _, res := range results {
 		line := []string{
 			res.Name,
 			fmt.Sprintf("%.2f", res.Bandwidth/1024/1024),
 			strconv.FormatInt(res.TTFB.Milliseconds(), 10),
+			strconv.Itoa(int(res.Delay)),
+			strconv.Itoa(int(res.Jitter)),
+			fmt.Sprintf("%.0f", res.SuccessRate*100),
+			strconv.Itoa(res.FailedStreams),
+			strconv.Itoa(len(res.Rounds)),
+			formatStat(res.BandwidthStats, func(s *result.Stats) float64 { return s.Median / 1024 / 1024 }),
+			formatStat(res.BandwidthStats, func(s *result.Stats) float64 { return s.StdDev / 1024 / 1024 }),
+			formatStat(res.BandwidthStats, func(s *result.Stats) float64 { return s.CI95 / 1024 / 1024 }),
+			formatStat(res.TTFBStats, func(s *result.Stats) float64 { return s.Median }),
+			formatStat(res.TTFBStats, func(s *result.Stats) float64 { return s.StdDev }),
+			formatStat(res.TTFBStats, func(s *result.Stats) float64 { return s.CI95 }),
+			formatRounds(res.Rounds, func(r result.Round) string { return fmt.Sprintf("%.2f", r.Bandwidth/1024/1024) }),
+			formatRounds(res.Rounds, func(r result.Round) string { return strconv.FormatInt(r.TTFB.Milliseconds(), 10) }),
+			fmt.Sprintf("%.2f", res.SingleStreamBandwidth/1024/1024),
+			strconv.Itoa(res.BestStreams),
+			strconv.FormatInt(res.IdleLatency.Milliseconds(), 10),
+			strconv.FormatInt(res.LoadedLatency.Milliseconds(), 10),
+			strconv.FormatInt(res.Bufferbloat().Milliseconds(), 10),
+			fmt.Sprintf("%.2f", float64(res.TestSize)/1024/1024),
+			fmt.Sprintf("%.1f", res.SoakUptime*100),
+			strconv.Itoa(res.SoakStalls),
+			strconv.Itoa(res.SoakResets),
+			strconv.Itoa(res.SoakReconnects),
+			fmt.Sprintf("%.2f", float64(res.Traffic)/1024/1024),
+			strconv.FormatBool(res.Skipped),
+			fmt.Sprintf("%.1f", res.Score),
+			res.Status,
+			strings.Join(res.FailReasons, "; "),
+			res.OutBoundIp,
+			res.Country,
+			res.ClaimedRegion,
+			strconv.FormatBool(res.RegionMismatch),
+			strconv.FormatFloat(res.Multiplier, 'f', -1, 64),
+			fmt.Sprintf("%.2f", res.CostAdjustedBandwidth()/1024/1024),
+			res.City,
+			result.FormatASN(res.ASN),
+			res.Org,
+			res.ExitType,
+			res.Server,
+			res.Type,
+			res.Source,
+			res.EntryIP,
+			res.EntryCountry,
+			result.FormatASN(res.EntryASN),
+			res.EntryOrg,
+			formatCluster(res.ExitCluster),
+			formatCluster(res.EntryCluster),
+			res.UDPStatus,
+			strconv.FormatInt(res.UDPRTT.Milliseconds(), 10),
+			fmt.Sprintf("%.0f", res.UDPLoss*100),
+			res.NATType,
 		}
 		writer.Write(line)
 	}
 
 	return nil
 }
+
+func formatStat(s *result.Stats, value func(*result.Stats) float64) string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.2f", value(s))
+}
+
+// formatRounds 以分号连接各轮的原始值
+func formatRounds(rounds []result.Round, value func(result.Round) string) string {
+	values := make([]string, len(rounds))
+	for i, r := range rounds {
+		values[i] = value(r)
+	}
+	return strings.Join(values, ";")
+}
+
+func formatCluster(id int) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.Itoa(id)
+}
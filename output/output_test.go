@@ -0,0 +1,44 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+func TestReadReport(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "results.json")
+	meta := Metadata{Version: "v1.0.0", Mode: "bandwidth", StartTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := WriteResultsToFile("json", path, []result.Result{{Name: "a", Bandwidth: 1}}, nil, meta); err != nil {
+		t.Fatal(err)
+	}
+	report, err := ReadReport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Schema != SchemaVersion || report.Meta.Version != "v1.0.0" || !report.Meta.StartTime.Equal(meta.StartTime) || len(report.Results) != 1 {
+		t.Errorf("ReadReport() = %+v", report)
+	}
+
+	// 早期版本直接写出结果数组
+	legacy := filepath.Join(dir, "legacy.json")
+	os.WriteFile(legacy, []byte(`[{"name":"a","bandwidth":1},{"name":"b","delay":9999}]`), 0o644)
+	report, err = ReadReport(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Schema != 0 || len(report.Results) != 2 || report.Results[1].Delay != 9999 {
+		t.Errorf("ReadReport(legacy) = %+v", report)
+	}
+
+	future := filepath.Join(dir, "future.json")
+	os.WriteFile(future, []byte(`{"schema":99,"results":[]}`), 0o644)
+	if _, err := ReadReport(future); err == nil {
+		t.Error("ReadReport() accepted a newer schema")
+	}
+}
@@ -0,0 +1,60 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+// SchemaVersion 为 JSON 结果文件的格式版本，字段发生不兼容的变化时递增。
+// 版本 0 为早期直接写出的结果数组，没有运行信息。
+const SchemaVersion = 1
+
+// Metadata 为一次测试的运行信息
+type Metadata struct {
+	// Version 为写出结果的工具版本
+	Version string `json:"version"`
+	// Mode 为测试类型：bandwidth、delay、rounds 或 soak
+	Mode      string    `json:"mode"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Host      string    `json:"host,omitempty"`
+	// Parameters 为命令行中显式设置的参数
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// Report 为 -w json 写出的结果文件
+type Report struct {
+	Schema  int             `json:"schema"`
+	Meta    Metadata        `json:"meta"`
+	Results []result.Result `json:"results"`
+}
+
+// ReadReport 读取 -w json 写出的结果文件，兼容早期只有结果数组的格式
+func ReadReport(filePath string) (Report, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return Report{}, err
+	}
+
+	var report Report
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &report.Results)
+	} else {
+		err = json.Unmarshal(data, &report)
+	}
+	if err != nil {
+		return Report{}, fmt.Errorf("parse %s: %w", filePath, err)
+	}
+	if report.Schema > SchemaVersion {
+		return Report{}, fmt.Errorf("%s uses schema %d, this version only reads up to %d", filePath, report.Schema, SchemaVersion)
+	}
+	if report.Results == nil {
+		return Report{}, fmt.Errorf("%s contains no results", filePath)
+	}
+	return report, nil
+}
@@ -0,0 +1,141 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+const (
+	StatusPass = "pass"
+	StatusFail = "fail"
+)
+
+// Policy 为判定节点及整次测试是否合格的阈值，零值表示不检查该项
+type Policy struct {
+	// MinBandwidth 单位为 B/s
+	MinBandwidth float64
+	MaxTTFB      time.Duration
+	MaxDelay     time.Duration
+	// MinPassCount、MinPassPercent 为整次测试至少需要的合格节点数与比例（0~100）
+	MinPassCount      int
+	MinPassPercent    float64
+	RequiredCountries []string
+}
+
+// Enabled 判断是否设置了任意阈值
+func (p Policy) Enabled() bool {
+	return p.MinBandwidth > 0 || p.MaxTTFB > 0 || p.MaxDelay > 0 ||
+		p.MinPassCount > 0 || p.MinPassPercent > 0 || len(p.RequiredCountries) > 0
+}
+
+// ParseCountries 解析以逗号分隔的国家代码列表，去除空白并转换为大写，忽略空项
+func ParseCountries(s string) []string {
+	var countries []string
+	for _, country := range strings.Split(s, ",") {
+		if country = strings.ToUpper(strings.TrimSpace(country)); country != "" {
+			countries = append(countries, country)
+		}
+	}
+	return countries
+}
+
+// Summary 为整次测试的判定结果
+type Summary struct {
+	Total            int
+	Passed           int
+	MissingCountries []string
+	OK               bool
+}
+
+func (s Summary) PassPercent() float64 {
+	if s.Total == 0 {
+		return 0
+	}
+	return float64(s.Passed) * 100 / float64(s.Total)
+}
+
+func delayOK(r *result.Result) bool {
+	return r.Delay > 0 && r.Delay != 9999
+}
+
+func alive(r *result.Result) bool {
+	return r.Bandwidth > 0 || delayOK(r)
+}
+
+// delayOnly 判断结果是否只进行了延迟测试，此时不检查带宽与 TTFB
+func delayOnly(r *result.Result) bool {
+	return r.Bandwidth == 0 && r.TTFB == 0 && delayOK(r)
+}
+
+// Check 判定单个节点是否合格，返回不合格的原因
+func (p Policy) Check(r *result.Result) []string {
+	if r.Skipped {
+		return []string{"skipped"}
+	}
+	if !alive(r) {
+		return []string{"unreachable"}
+	}
+
+	reasons := make([]string, 0)
+	if p.MinBandwidth > 0 && !delayOnly(r) && r.Bandwidth < p.MinBandwidth {
+		reasons = append(reasons, fmt.Sprintf("bandwidth %.2fMB/s < %.2fMB/s", r.Bandwidth/1024/1024, p.MinBandwidth/1024/1024))
+	}
+	if p.MaxTTFB > 0 && !delayOnly(r) && (r.TTFB <= 0 || r.TTFB > p.MaxTTFB) {
+		reasons = append(reasons, fmt.Sprintf("ttfb %dms > %dms", r.TTFB.Milliseconds(), p.MaxTTFB.Milliseconds()))
+	}
+	if p.MaxDelay > 0 && r.Delay > 0 && time.Duration(r.Delay)*time.Millisecond > p.MaxDelay {
+		reasons = append(reasons, fmt.Sprintf("delay %dms > %dms", r.Delay, p.MaxDelay.Milliseconds()))
+	}
+	return reasons
+}
+
+// Evaluate 为每个结果写入 Status 与 FailReasons，并判定整次测试是否满足策略
+func (p Policy) Evaluate(results []result.Result) Summary {
+	s := Summary{Total: len(results)}
+	countries := make(map[string]bool)
+
+	for i := range results {
+		reasons := p.Check(&results[i])
+		if len(reasons) == 0 {
+			results[i].Status = StatusPass
+			results[i].FailReasons = nil
+			s.Passed++
+			countries[strings.ToUpper(results[i].Country)] = true
+		} else {
+			results[i].Status = StatusFail
+			results[i].FailReasons = reasons
+		}
+	}
+
+	for _, country := range p.RequiredCountries {
+		if !countries[strings.ToUpper(country)] {
+			s.MissingCountries = append(s.MissingCountries, country)
+		}
+	}
+	sort.Strings(s.MissingCountries)
+
+	s.OK = s.Passed >= p.MinPassCount &&
+		s.PassPercent() >= p.MinPassPercent &&
+		len(s.MissingCountries) == 0
+	return s
+}
+
+// Display 输出整次测试的判定结果
+func (s Summary) Display(p Policy) {
+	status := "PASSED"
+	if !s.OK {
+		status = "FAILED"
+	}
+	fmt.Printf("\nPolicy %s: %d/%d nodes passed (%.1f%%)", status, s.Passed, s.Total, s.PassPercent())
+	if p.MinPassCount > 0 || p.MinPassPercent > 0 {
+		fmt.Printf(", required at least %d nodes and %.1f%%", p.MinPassCount, p.MinPassPercent)
+	}
+	fmt.Println()
+	if len(s.MissingCountries) > 0 {
+		fmt.Printf("Required countries without a passing node: %s\n", strings.Join(s.MissingCountries, ", "))
+	}
+}
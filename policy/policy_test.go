@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+func TestEvaluate(t *testing.T) {
+	results := []result.Result{
+		{Name: "fast", Country: "HK", Bandwidth: 10 * 1024 * 1024, TTFB: 200 * time.Millisecond},
+		{Name: "slow", Country: "JP", Bandwidth: 512 * 1024, TTFB: 200 * time.Millisecond},
+		{Name: "laggy", Country: "US", Bandwidth: 10 * 1024 * 1024, TTFB: 2 * time.Second},
+		{Name: "dead", Bandwidth: -1, TTFB: -1},
+	}
+
+	p := Policy{
+		MinBandwidth:      1024 * 1024,
+		MaxTTFB:           time.Second,
+		MinPassCount:      1,
+		RequiredCountries: []string{"hk", "JP"},
+	}
+	s := p.Evaluate(results)
+
+	expected := []string{StatusPass, StatusFail, StatusFail, StatusFail}
+	for i, res := range results {
+		if res.Status != expected[i] {
+			t.Errorf("%s status = %s (%v); want %s", res.Name, res.Status, res.FailReasons, expected[i])
+		}
+	}
+	if s.Passed != 1 || s.PassPercent() != 25 {
+		t.Errorf("summary = %+v; want 1 of 4 passed", s)
+	}
+	if s.OK || len(s.MissingCountries) != 1 || s.MissingCountries[0] != "JP" {
+		t.Errorf("summary = %+v; want failure with JP missing", s)
+	}
+
+	p.RequiredCountries = []string{"HK"}
+	if s := p.Evaluate(results); !s.OK {
+		t.Errorf("summary = %+v; want policy met", s)
+	}
+	p.MinPassPercent = 50
+	if s := p.Evaluate(results); s.OK {
+		t.Errorf("summary = %+v; want failure below 50%%", s)
+	}
+
+	// -require-countries 中的空白、小写与空项
+	p = Policy{MinBandwidth: 1024 * 1024, RequiredCountries: ParseCountries(" hk, jp ,,")}
+	if len(p.RequiredCountries) != 2 || p.RequiredCountries[0] != "HK" || p.RequiredCountries[1] != "JP" {
+		t.Errorf("ParseCountries = %q; want [HK JP]", p.RequiredCountries)
+	}
+	if s := p.Evaluate(results); s.OK || len(s.MissingCountries) != 1 || s.MissingCountries[0] != "JP" {
+		t.Errorf("summary = %+v; want failure with JP missing", s)
+	}
+	if ParseCountries("") != nil {
+		t.Errorf("ParseCountries(\"\") should be empty")
+	}
+}
+
+func TestDelayMode(t *testing.T) {
+	p := Policy{MinBandwidth: 1024 * 1024, MaxDelay: 300 * time.Millisecond}
+	if reasons := p.Check(&result.Result{Delay: 120}); len(reasons) != 0 {
+		t.Errorf("Check(delay 120) = %v; want pass", reasons)
+	}
+	if reasons := p.Check(&result.Result{Delay: 450}); len(reasons) != 1 {
+		t.Errorf("Check(delay 450) = %v; want one reason", reasons)
+	}
+	if reasons := p.Check(&result.Result{Delay: 9999}); len(reasons) != 1 || reasons[0] != "unreachable" {
+		t.Errorf("Check(delay 9999) = %v; want unreachable", reasons)
+	}
+}
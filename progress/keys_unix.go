@@ -0,0 +1,45 @@
+//go:build !windows
+
+package progress
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// unixKeys 从终端输入的副本读取按键。副本设置为非阻塞后由运行时轮询，
+// 关闭副本即可使阻塞中的 Read 返回，测试结束后不再占用终端输入
+type unixKeys struct {
+	fd          int
+	nonblocking bool
+	file        *os.File
+}
+
+func newKeyReader(in *os.File) (keyReader, error) {
+	fd := int(in.Fd())
+	flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+	if err != nil {
+		return nil, err
+	}
+	dup, err := unix.Dup(fd)
+	if err != nil {
+		return nil, err
+	}
+	// 非阻塞标志由两个描述符共享，Close 时恢复
+	if err := unix.SetNonblock(dup, true); err != nil {
+		unix.Close(dup)
+		return nil, err
+	}
+	return &unixKeys{fd: fd, nonblocking: flags&unix.O_NONBLOCK != 0, file: os.NewFile(uintptr(dup), in.Name())}, nil
+}
+
+func (k *unixKeys) Read(p []byte) (int, error) {
+	return k.file.Read(p)
+}
+
+func (k *unixKeys) Close() error {
+	err := k.file.Close()
+	unix.SetNonblock(k.fd, k.nonblocking)
+	return err
+}
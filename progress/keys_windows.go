@@ -0,0 +1,44 @@
+package progress
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// windowsKeys 等待控制台输入可读后再读取，关闭后 Read 在下一次等待超时时返回，
+// 测试结束后不再占用控制台输入
+type windowsKeys struct {
+	in   *os.File
+	done chan struct{}
+}
+
+const keyPollInterval = 100 * time.Millisecond
+
+func newKeyReader(in *os.File) (keyReader, error) {
+	return &windowsKeys{in: in, done: make(chan struct{})}, nil
+}
+
+func (k *windowsKeys) Read(p []byte) (int, error) {
+	for {
+		select {
+		case <-k.done:
+			return 0, io.EOF
+		default:
+		}
+		event, err := windows.WaitForSingleObject(windows.Handle(k.in.Fd()), uint32(keyPollInterval/time.Millisecond))
+		if err != nil {
+			return 0, err
+		}
+		if event == windows.WAIT_OBJECT_0 {
+			return k.in.Read(p)
+		}
+	}
+}
+
+func (k *windowsKeys) Close() error {
+	close(k.done)
+	return nil
+}
@@ -0,0 +1,325 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+	"golang.org/x/term"
+)
+
+const (
+	refreshInterval = 250 * time.Millisecond
+	barWidth        = 40
+)
+
+// TUI 在终端中实时显示带宽测试进度：整体进度与预计剩余时间、当前节点、
+// 各并发连接的实时速度，以及随测试完成不断重新排序的结果表。
+// 按 s 跳过当前节点，按 q 或 Ctrl-C 中止测试。
+type TUI struct {
+	out    *os.File
+	in     *os.File
+	sortBy string
+	abort  context.CancelFunc
+
+	mu        sync.Mutex
+	total     int
+	finished  int
+	skipped   int
+	begin     time.Time
+	current   string
+	nodeBegin time.Time
+	streams   []int64
+	last      []int64
+	rates     []float64
+	lastTick  time.Time
+	skip      context.CancelFunc
+	results   []result.Result
+	message   string
+
+	state   *term.State
+	keys    keyReader
+	aborted bool
+	stopped bool
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New 在 out 为终端时返回 TUI，否则返回 nil，调用方应回退到逐行输出。
+// in 为终端时读取按键，abort 用于中止整个测试。
+func New(out *os.File, in *os.File, sortBy string, abort context.CancelFunc) *TUI {
+	if !term.IsTerminal(int(out.Fd())) {
+		return nil
+	}
+	return &TUI{out: out, in: in, sortBy: sortBy, abort: abort}
+}
+
+// Begin 开始显示一次测试的进度。多轮测试时每轮调用一次 Begin 与 End，每轮重新计数
+func (t *TUI) Begin(total int) {
+	t.mu.Lock()
+	t.total = total
+	t.finished, t.skipped = 0, 0
+	t.results = nil
+	t.current, t.message = "", ""
+	t.begin = time.Now()
+	t.state, t.keys = nil, nil
+	t.stopped = false
+	t.stop = make(chan struct{})
+	t.mu.Unlock()
+
+	// 切换到备用屏幕并关闭自动换行，结束后恢复
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?7l\x1b[?25l")
+	if t.in != nil && term.IsTerminal(int(t.in.Fd())) {
+		if state, err := term.MakeRaw(int(t.in.Fd())); err == nil {
+			t.state = state
+			t.listen()
+		}
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.render()
+			}
+		}
+	}()
+	t.render()
+}
+
+func (t *TUI) Start(name string, streams []int64, skip context.CancelFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.current = name
+	t.nodeBegin = time.Now()
+	t.streams = streams
+	t.last = make([]int64, len(streams))
+	t.rates = make([]float64, len(streams))
+	t.lastTick = t.nodeBegin
+	t.skip = skip
+	t.message = ""
+}
+
+func (t *TUI) Done(res result.Result, skipped bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.finished++
+	if skipped {
+		t.skipped++
+	} else {
+		t.results = append(t.results, res)
+	}
+	t.current = ""
+	t.streams = nil
+	t.skip = nil
+}
+
+func (t *TUI) End() {
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		return
+	}
+	t.stopped = true
+	t.mu.Unlock()
+
+	close(t.stop)
+	// 先停止读取按键再恢复终端，之后的输入留给 shell 或后续的读取者
+	if t.keys != nil {
+		t.keys.Close()
+	}
+	t.wg.Wait()
+	if t.state != nil {
+		term.Restore(int(t.in.Fd()), t.state)
+	}
+	fmt.Fprint(t.out, "\x1b[?25h\x1b[?7h\x1b[?1049l")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.out, "Tested %d/%d nodes in %s", t.finished-t.skipped, t.total, time.Since(t.begin).Round(time.Second))
+	if t.skipped > 0 {
+		fmt.Fprintf(t.out, ", %d skipped", t.skipped)
+	}
+	fmt.Fprintln(t.out)
+}
+
+// keyReader 为可以中断的按键输入，Close 使阻塞中的 Read 返回
+type keyReader interface {
+	Read(p []byte) (int, error)
+	Close() error
+}
+
+// listen 开始读取按键，End 时停止
+func (t *TUI) listen() {
+	keys, err := newKeyReader(t.in)
+	if err != nil {
+		return
+	}
+	t.keys = keys
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		t.readKeys()
+	}()
+}
+
+// readKeys 读取单个按键。终端处于 raw 模式，Ctrl-C 不会产生信号，需要在这里处理。
+func (t *TUI) readKeys() {
+	buf := make([]byte, 1)
+	for {
+		n, err := t.keys.Read(buf)
+		if err != nil {
+			return
+		}
+		if n == 0 {
+			continue
+		}
+
+		t.mu.Lock()
+		if t.stopped {
+			t.mu.Unlock()
+			return
+		}
+		switch buf[0] {
+		case 's', 'S':
+			if t.skip != nil {
+				t.skip()
+				t.message = "Skipping " + t.current
+			}
+		case 'q', 'Q', 3:
+			// 再次按下 Ctrl-C 时立即退出
+			if t.aborted && buf[0] == 3 {
+				if t.state != nil {
+					term.Restore(int(t.in.Fd()), t.state)
+				}
+				fmt.Fprint(t.out, "\x1b[?25h\x1b[?7h\x1b[?1049l")
+				os.Exit(130)
+			}
+			t.aborted = true
+			t.message = "Aborting, waiting for the current node to stop (press Ctrl-C again to quit immediately)"
+			t.abort()
+		}
+		t.mu.Unlock()
+	}
+}
+
+func (t *TUI) render() {
+	t.mu.Lock()
+	lines := t.lines()
+	t.mu.Unlock()
+
+	_, height, err := term.GetSize(int(t.out.Fd()))
+	if err == nil && height > 0 && len(lines) > height {
+		// 保留底部的按键提示
+		lines = append(lines[:height-1], lines[len(lines)-1])
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\x1b[H")
+	for i, line := range lines {
+		buf.WriteString(line)
+		buf.WriteString("\x1b[K")
+		if i < len(lines)-1 {
+			buf.WriteString("\r\n")
+		}
+	}
+	buf.WriteString("\x1b[J")
+	t.out.Write(buf.Bytes())
+}
+
+// lines 生成当前画面，调用时需持有 t.mu
+func (t *TUI) lines() []string {
+	now := time.Now()
+	elapsed := now.Sub(t.begin)
+	lines := make([]string, 0, 32)
+
+	eta := "--"
+	if t.finished > 0 && t.finished < t.total {
+		perNode := elapsed / time.Duration(t.finished)
+		eta = (perNode * time.Duration(t.total-t.finished)).Round(time.Second).String()
+	}
+	percent := 0.0
+	if t.total > 0 {
+		percent = float64(t.finished) * 100 / float64(t.total)
+	}
+	lines = append(lines,
+		fmt.Sprintf("Progress %d/%d (%.0f%%)  elapsed %s  ETA %s", t.finished, t.total, percent, elapsed.Round(time.Second), eta),
+		progressBar(t.finished, t.total),
+		"",
+	)
+
+	if t.current != "" {
+		lines = append(lines, fmt.Sprintf("Testing %s  %s", t.current, now.Sub(t.nodeBegin).Round(time.Second)))
+
+		// 按刷新间隔计算各连接的实时速度
+		if dt := now.Sub(t.lastTick).Seconds(); dt >= refreshInterval.Seconds()/2 {
+			for i := range t.streams {
+				n := atomic.LoadInt64(&t.streams[i])
+				t.rates[i] = float64(n-t.last[i]) / dt
+				t.last[i] = n
+			}
+			t.lastTick = now
+		}
+		total, downloaded := 0.0, int64(0)
+		for i := range t.streams {
+			total += t.rates[i]
+			downloaded += t.last[i]
+			lines = append(lines, fmt.Sprintf("  stream %-3d %12s  %10s", i+1, formatRate(t.rates[i]), formatBytes(t.last[i])))
+		}
+		lines = append(lines, fmt.Sprintf("  total      %12s  %10s", formatRate(total), formatBytes(downloaded)))
+	}
+	if t.message != "" {
+		lines = append(lines, t.message)
+	}
+
+	if len(t.results) > 0 {
+		results := make([]result.Result, len(t.results))
+		copy(results, t.results)
+		result.SortResults(results, t.sortBy)
+
+		var table bytes.Buffer
+		result.WriteTable(&table, results)
+		lines = append(lines, "")
+		lines = append(lines, strings.Split(strings.TrimRight(table.String(), "\n"), "\n")...)
+	}
+
+	lines = append(lines, "", "[s] skip node  [q] abort")
+	return lines
+}
+
+func progressBar(done, total int) string {
+	filled := 0
+	if total > 0 {
+		filled = done * barWidth / total
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", barWidth-filled) + "]"
+}
+
+func formatRate(v float64) string {
+	return formatBytes(int64(v)) + "/s"
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1024*1024*1024:
+		return fmt.Sprintf("%.2fGB", float64(n)/1024/1024/1024)
+	case n >= 1024*1024:
+		return fmt.Sprintf("%.2fMB", float64(n)/1024/1024)
+	case n >= 1024:
+		return fmt.Sprintf("%.2fKB", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
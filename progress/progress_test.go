@@ -0,0 +1,99 @@
+package progress
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+func TestKeysStopOnEnd(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	out, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	skipped := make(chan struct{})
+	tui := &TUI{out: out, in: r, abort: func() {}}
+	tui.Begin(1)
+	tui.listen()
+	tui.Start("HK 01", []int64{0}, func() { close(skipped) })
+
+	w.Write([]byte("s"))
+	select {
+	case <-skipped:
+	case <-time.After(time.Second):
+		t.Fatal("s did not skip the current node")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		tui.End()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("End blocked on the key reader")
+	}
+
+	// 结束后的输入不应被读取按键的 goroutine 吞掉
+	w.Write([]byte("x"))
+	buf := make([]byte, 1)
+	r.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := r.Read(buf); err != nil || n != 1 || buf[0] != 'x' {
+		t.Errorf("read after End = %q, %v; want x", buf[:n], err)
+	}
+}
+
+func TestBeginAfterEnd(t *testing.T) {
+	out, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	// 多轮测试中每轮调用一次 Begin 与 End
+	tui := &TUI{out: out, abort: func() {}}
+	for round := 0; round < 2; round++ {
+		tui.Begin(2)
+		tui.Start("HK 01", []int64{0}, func() {})
+		tui.Done(result.Result{Name: "HK 01", Bandwidth: 1}, false)
+		tui.Start("JP 01", []int64{0}, func() {})
+		tui.Done(result.Result{Name: "JP 01"}, true)
+		tui.End()
+	}
+	if tui.finished != 2 || tui.skipped != 1 || len(tui.results) != 1 {
+		t.Errorf("second round counted finished %d skipped %d results %d", tui.finished, tui.skipped, len(tui.results))
+	}
+
+	data, _ := os.ReadFile(out.Name())
+	// 每轮都离开备用屏幕并恢复光标
+	if enter, leave := bytes.Count(data, []byte("\x1b[?1049h")), bytes.Count(data, []byte("\x1b[?25h\x1b[?7h\x1b[?1049l")); enter != 2 || leave != 2 {
+		t.Errorf("entered the alternate screen %d times, left %d times", enter, leave)
+	}
+	if n := bytes.Count(data, []byte("Tested 1/2 nodes")); n != 2 {
+		t.Errorf("summary printed %d times:\n%s", n, data)
+	}
+}
+
+func TestNewRequiresTerminal(t *testing.T) {
+	out, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if New(out, nil, "b", context.CancelFunc(func() {})) != nil {
+		t.Error("New returned a TUI for a regular file")
+	}
+}
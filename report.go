@@ -0,0 +1,153 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/0x10240/mihomo-speedtest/config"
+	"github.com/0x10240/mihomo-speedtest/nodename"
+	"github.com/0x10240/mihomo-speedtest/output"
+	"github.com/0x10240/mihomo-speedtest/policy"
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+// runReport 实现 report 子命令：读取 -w json 写出的结果，按新的 -f、-sort、-score-weights
+// 与阈值策略重新显示，并可通过 -w、-o 转换为其他格式，不重新测试
+func runReport(args []string) {
+	flag.CommandLine.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s report [-f regex] [-sort fields] [policy flags] [-w format -o file] results.json\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.Parse(args)
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	report, err := output.ReadReport(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read results: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := result.ParseSortSpec(*sortField); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -sort: %v\n", err)
+		os.Exit(1)
+	}
+	weights, err := result.ParseScoreWeights(*scoreWeights)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -score-weights: %v\n", err)
+		os.Exit(1)
+	}
+	multiplierPatterns, err := nodename.ParseMultiplierPattern(*multiplierPattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -multiplier-regex: %v\n", err)
+		os.Exit(1)
+	}
+	filterRegexp, err := regexp.Compile(*filterRegexConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -f: %v\n", err)
+		os.Exit(1)
+	}
+
+	results := make([]result.Result, 0, len(report.Results))
+	for _, res := range report.Results {
+		if filterRegexp.MatchString(res.Name) {
+			results = append(results, res)
+		}
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(os.Stderr, "No matching results found")
+		os.Exit(1)
+	}
+	if report.Meta.Mode == "" {
+		report.Meta.Mode = inferMode(results)
+	}
+	if !report.Meta.StartTime.IsZero() {
+		fmt.Printf("Results of %s test started at %s on %s (version %s)\n",
+			report.Meta.Mode, report.Meta.StartTime.Local().Format("2006-01-02 15:04:05"), report.Meta.Host, report.Meta.Version)
+	}
+
+	// 指定了 -multiplier-regex 或旧文件中没有倍率时按名称重新解析，否则沿用文件中的倍率
+	if *multiplierPattern != "" || !hasStoredMultipliers(results) {
+		nodename.AnnotateMultipliers(results, multiplierPatterns)
+	}
+	// 评分按本次显示的结果重新归一化
+	result.ScoreResults(results, weights)
+	exitClusters, entryClusters := result.AssignClusters(results)
+
+	pol := parsePolicy()
+	usePolicy := pol.Enabled() && report.Meta.Mode != "soak"
+	var summary policy.Summary
+	if usePolicy {
+		summary = pol.Evaluate(results)
+	}
+
+	switch report.Meta.Mode {
+	case "soak":
+		result.DisplaySoakResults(results)
+	case "delay":
+		result.DisplayDelayResult(results, delaySort())
+		result.DisplayClusters(results, exitClusters, entryClusters)
+	default:
+		if *sortField != "" {
+			result.SortResults(results, *sortField)
+		}
+		result.DisplayResults(results, *sortField)
+		result.DisplayClusters(results, exitClusters, entryClusters)
+	}
+	if usePolicy {
+		summary.Display(pol)
+	}
+
+	if *outputFormat != "" {
+		// 完整配置需要原始节点，只有指定 -c 时才能输出 yaml
+		var proxies map[string]config.CProxy
+		if *configPathConfig != "" {
+			proxies = config.LoadAllProxies(*configPathConfig, *proxy, *forwardProxy)
+		} else if *outputFormat == "yaml" {
+			fmt.Fprintln(os.Stderr, "-w yaml needs -c to load the original node configuration")
+			os.Exit(1)
+		}
+		if err := output.WriteResultsToFile(*outputFormat, *outputFile, results, proxies, report.Meta); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write results to file: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Results have been written to the %s file\n", *outputFormat)
+	}
+
+	if usePolicy && !summary.OK {
+		os.Exit(2)
+	}
+}
+
+// inferMode 推断早期结果文件的测试类型
+func inferMode(results []result.Result) string {
+	delayOnly := true
+	for _, res := range results {
+		if res.SoakDuration > 0 {
+			return "soak"
+		}
+		if len(res.Rounds) > 0 {
+			return "rounds"
+		}
+		if res.Bandwidth > 0 || res.TTFB > 0 || res.Delay == 0 {
+			delayOnly = false
+		}
+	}
+	if delayOnly {
+		return "delay"
+	}
+	return "bandwidth"
+}
+
+// hasStoredMultipliers 判断结果文件中是否保存了倍率，旧文件中倍率全部为 0
+func hasStoredMultipliers(results []result.Result) bool {
+	for _, res := range results {
+		if res.Multiplier != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package result
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Cluster 为共享同一出口 IP 或入口服务器的一组节点
+type Cluster struct {
+	ID    int
+	Key   string
+	Names []string
+}
+
+// ClusterBy 按 key 对结果分组，只返回包含两个及以上节点的分组，key 为空的结果不参与分组
+func ClusterBy(results []Result, key func(Result) string) []Cluster {
+	groups := make(map[string][]string)
+	for _, res := range results {
+		if k := key(res); k != "" {
+			groups[k] = append(groups[k], res.Name)
+		}
+	}
+
+	clusters := make([]Cluster, 0)
+	for k, names := range groups {
+		if len(names) < 2 {
+			continue
+		}
+		sort.Strings(names)
+		clusters = append(clusters, Cluster{Key: k, Names: names})
+	}
+	// 节点多的分组在前
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].Names) != len(clusters[j].Names) {
+			return len(clusters[i].Names) > len(clusters[j].Names)
+		}
+		return clusters[i].Key < clusters[j].Key
+	})
+	for i := range clusters {
+		clusters[i].ID = i + 1
+	}
+	return clusters
+}
+
+func exitKey(r Result) string { return r.OutBoundIp }
+
+// entryKey 按入口 IP 分组，不同域名或端口可能指向同一台入口服务器；未解析出入口 IP 时使用服务器主机名
+func entryKey(r Result) string {
+	if r.EntryIP != "" {
+		return r.EntryIP
+	}
+	host, _, err := net.SplitHostPort(r.Server)
+	if err != nil {
+		return r.Server
+	}
+	return host
+}
+
+// AssignClusters 为共享出口 IP 或入口服务器的结果写入分组编号，并返回两类分组
+func AssignClusters(results []Result) (exit []Cluster, entry []Cluster) {
+	exit = ClusterBy(results, exitKey)
+	entry = ClusterBy(results, entryKey)
+
+	exitIDs := make(map[string]int)
+	for _, c := range exit {
+		exitIDs[c.Key] = c.ID
+	}
+	entryIDs := make(map[string]int)
+	for _, c := range entry {
+		entryIDs[c.Key] = c.ID
+	}
+	for i := range results {
+		results[i].ExitCluster = exitIDs[exitKey(results[i])]
+		results[i].EntryCluster = entryIDs[entryKey(results[i])]
+	}
+	return exit, entry
+}
+
+// DisplayClusters 输出共享出口 IP 与入口服务器的节点分组
+func DisplayClusters(results []Result, exit []Cluster, entry []Cluster) {
+	byName := make(map[string]Result, len(results))
+	for _, res := range results {
+		byName[res.Name] = res
+	}
+
+	if len(exit) > 0 {
+		fmt.Printf("\nNodes sharing an exit IP:\n")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"#", "Exit IP", "Country", "Count", "Nodes"})
+		for _, c := range exit {
+			table.Append([]string{
+				fmt.Sprintf("%d", c.ID),
+				c.Key,
+				byName[c.Names[0]].Country,
+				fmt.Sprintf("%d", len(c.Names)),
+				formatNames(c.Names),
+			})
+		}
+		table.Render()
+	}
+
+	if len(entry) > 0 {
+		fmt.Printf("\nNodes sharing an entry server:\n")
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"#", "Entry", "Count", "Nodes"})
+		for _, c := range entry {
+			table.Append([]string{
+				fmt.Sprintf("%d", c.ID),
+				c.Key,
+				fmt.Sprintf("%d", len(c.Names)),
+				formatNames(c.Names),
+			})
+		}
+		table.Render()
+	}
+}
+
+func formatNames(names []string) string {
+	formatted := make([]string, len(names))
+	for i, name := range names {
+		formatted[i] = formatName(name)
+	}
+	return strings.Join(formatted, "\n")
+}
+
+// PairResult 为两个节点同时测速的结果，用于判断是否共享带宽
+type PairResult struct {
+	A, B            string
+	AloneA, AloneB  float64
+	TogetherA       float64
+	TogetherB       float64
+	SharedBandwidth bool
+}
+
+// DisplayPairResults 输出并发配对测试的结果
+func DisplayPairResults(pairs []PairResult) {
+	if len(pairs) == 0 {
+		return
+	}
+	fmt.Printf("\nConcurrent pair test:\n")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Node A", "Node B", "Alone", "Together", "Shared"})
+	for _, p := range pairs {
+		shared := "no"
+		if p.SharedBandwidth {
+			shared = "YES"
+		}
+		table.Append([]string{
+			formatName(p.A),
+			formatName(p.B),
+			fmt.Sprintf("%s + %s", FormatBandwidth(p.AloneA), FormatBandwidth(p.AloneB)),
+			fmt.Sprintf("%s + %s", FormatBandwidth(p.TogetherA), FormatBandwidth(p.TogetherB)),
+			shared,
+		})
+	}
+	table.Render()
+}
@@ -3,9 +3,11 @@ package result
 import (
 	"fmt"
 	"github.com/olekukonko/tablewriter"
+	"io"
+	"math"
 	"os"
 	"regexp"
-	"sort"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -18,10 +20,108 @@ type Result struct {
 	Bandwidth  float64       `json:"bandwidth" yaml:"bandwidth"`
 	TTFB       time.Duration `json:"ttfb" yaml:"ttfb"`
 	Delay      uint16        `json:"delay" yaml:"delay"`
+	// Server 为节点配置中的入口服务器地址
+	Server string `json:"server,omitempty" yaml:"server,omitempty"`
+	// Type 为节点的协议类型，Source 为节点来自的配置文件或订阅域名
+	Type   string `json:"type,omitempty" yaml:"type,omitempty"`
+	Source string `json:"source,omitempty" yaml:"source,omitempty"`
+
+	// Jitter 为多次延迟测试之间的平均波动（ms）
+	Jitter uint16 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	// SuccessRate 为延迟测试或并发下载中成功的比例
+	SuccessRate float64 `json:"success_rate" yaml:"success_rate"`
+	// FailedStreams 为并发下载中失败的连接数
+	FailedStreams int `json:"failed_streams,omitempty" yaml:"failed_streams,omitempty"`
+	// TestSize 为计算带宽所用的下载大小（字节），自适应模式下按探测速度决定
+	TestSize int64 `json:"test_size,omitempty" yaml:"test_size,omitempty"`
+	// Traffic 为测试该节点时经过代理的全部流量（字节），包括上传与下载
+	Traffic int64 `json:"traffic,omitempty" yaml:"traffic,omitempty"`
+	// SingleStreamBandwidth 为单连接带宽，BestStreams 为带宽最高时的并发连接数，仅在并发扫描时记录
+	SingleStreamBandwidth float64 `json:"single_stream_bandwidth,omitempty" yaml:"single_stream_bandwidth,omitempty"`
+	BestStreams           int     `json:"best_streams,omitempty" yaml:"best_streams,omitempty"`
+	// IdleLatency、LoadedLatency 为下载前与下载期间测得的延迟中位数，两者之差反映缓冲膨胀
+	IdleLatency   time.Duration `json:"idle_latency,omitempty" yaml:"idle_latency,omitempty"`
+	LoadedLatency time.Duration `json:"loaded_latency,omitempty" yaml:"loaded_latency,omitempty"`
+	// Rounds 为多轮测试中每一轮的原始结果，此时 Bandwidth、TTFB 为成功各轮的均值
+	Rounds         []Round `json:"rounds,omitempty" yaml:"rounds,omitempty"`
+	BandwidthStats *Stats  `json:"bandwidth_stats,omitempty" yaml:"bandwidth_stats,omitempty"`
+	// TTFBStats 的单位为 ms
+	TTFBStats *Stats `json:"ttfb_stats,omitempty" yaml:"ttfb_stats,omitempty"`
+	// SoakUptime 为长时间稳定性测试中有数据流动的时间比例，
+	// SoakStalls、SoakResets、SoakReconnects 为卡顿、连接被重置与重新连接的次数
+	SoakDuration   time.Duration `json:"soak_duration,omitempty" yaml:"soak_duration,omitempty"`
+	SoakUptime     float64       `json:"soak_uptime,omitempty" yaml:"soak_uptime,omitempty"`
+	SoakStalls     int           `json:"soak_stalls,omitempty" yaml:"soak_stalls,omitempty"`
+	SoakResets     int           `json:"soak_resets,omitempty" yaml:"soak_resets,omitempty"`
+	SoakReconnects int           `json:"soak_reconnects,omitempty" yaml:"soak_reconnects,omitempty"`
+	// Skipped 表示达到流量上限后未测试该节点
+	Skipped bool `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+	// Score 为按权重综合各项指标得到的 0~100 评分
+	Score float64 `json:"score" yaml:"score"`
+	// Status 为按阈值策略判定的结果：pass 或 fail，FailReasons 为不合格的原因
+	Status      string   `json:"status,omitempty" yaml:"status,omitempty"`
+	FailReasons []string `json:"fail_reasons,omitempty" yaml:"fail_reasons,omitempty"`
+
+	City string `json:"city,omitempty" yaml:"city,omitempty"`
+	Org  string `json:"org,omitempty" yaml:"org,omitempty"`
+	ASN  uint32 `json:"asn,omitempty" yaml:"asn,omitempty"`
+	// ExitType 为根据 ASN 组织推断的出口类型：hosting 或 isp
+	ExitType string `json:"exit_type,omitempty" yaml:"exit_type,omitempty"`
+	// ClaimedRegion 为节点名称中声明的地区，RegionMismatch 表示其与出口国家不一致
+	ClaimedRegion  string `json:"claimed_region,omitempty" yaml:"claimed_region,omitempty"`
+	RegionMismatch bool   `json:"region_mismatch,omitempty" yaml:"region_mismatch,omitempty"`
+	// Multiplier 为节点名称中标注的流量倍率，未标注时为 1，0 表示免流量
+	Multiplier float64 `json:"multiplier" yaml:"multiplier"`
+
+	EntryIP      string `json:"entry_ip,omitempty" yaml:"entry_ip,omitempty"`
+	EntryCountry string `json:"entry_country,omitempty" yaml:"entry_country,omitempty"`
+	EntryCity    string `json:"entry_city,omitempty" yaml:"entry_city,omitempty"`
+	EntryASN     uint32 `json:"entry_asn,omitempty" yaml:"entry_asn,omitempty"`
+	EntryOrg     string `json:"entry_org,omitempty" yaml:"entry_org,omitempty"`
+
+	UDPStatus string        `json:"udp,omitempty" yaml:"udp,omitempty"`
+	UDPRTT    time.Duration `json:"udp_rtt,omitempty" yaml:"udp_rtt,omitempty"`
+	UDPLoss   float64       `json:"udp_loss,omitempty" yaml:"udp_loss,omitempty"`
+
+	NATType      string `json:"nat_type,omitempty" yaml:"nat_type,omitempty"`
+	NATMapping   string `json:"nat_mapping,omitempty" yaml:"nat_mapping,omitempty"`
+	NATFiltering string `json:"nat_filtering,omitempty" yaml:"nat_filtering,omitempty"`
+
+	// ExitCluster、EntryCluster 为共享出口 IP、入口服务器的分组编号，0 表示不与其他节点共享
+	ExitCluster  int      `json:"exit_cluster,omitempty" yaml:"exit_cluster,omitempty"`
+	EntryCluster int      `json:"entry_cluster,omitempty" yaml:"entry_cluster,omitempty"`
+	SharedWith   []string `json:"shared_bandwidth_with,omitempty" yaml:"shared_bandwidth_with,omitempty"`
+}
+
+const (
+	UDPStatusOK = "ok"
+	// UDPStatusBroken 表示节点配置声明支持 UDP，但实际无法转发
+	UDPStatusBroken      = "broken"
+	UDPStatusUnsupported = "unsupported"
+)
+
+// CostAdjustedBandwidth 返回按流量倍率折算后的带宽，即每单位计费流量的带宽。
+// 倍率为 0 的免流量节点不消耗计费流量，返回 +Inf。
+func (r *Result) CostAdjustedBandwidth() float64 {
+	if r.Bandwidth <= 0 || r.Multiplier < 0 {
+		return r.Bandwidth
+	}
+	if r.Multiplier == 0 {
+		return math.Inf(1)
+	}
+	return r.Bandwidth / r.Multiplier
+}
+
+// Bufferbloat 返回负载下延迟相对空闲延迟的增加量，未测试时返回 0
+func (r *Result) Bufferbloat() time.Duration {
+	if r.IdleLatency <= 0 || r.LoadedLatency <= 0 {
+		return 0
+	}
+	return r.LoadedLatency - r.IdleLatency
 }
 
 func (r *Result) Print() {
-	fmt.Printf("%-42s\t%-12s\t%-12s\n", formatName(r.Name), formatBandwidth(r.Bandwidth), formatMilliseconds(r.TTFB))
+	fmt.Printf("%-42s\t%-12s\t%-12s\n", formatName(r.Name), FormatBandwidth(r.Bandwidth), FormatMilliseconds(r.TTFB))
 }
 func formatName(name string) string {
 	// 使用过滤函数来移除 emoji 或符号字符
@@ -48,10 +148,14 @@ func removeEmoji(s string) string {
 	}, s)
 }
 
-func formatBandwidth(v float64) string {
+// FormatBandwidth 以合适的单位显示带宽，未测得时显示 N/A
+func FormatBandwidth(v float64) string {
 	if v <= 0 {
 		return "N/A"
 	}
+	if math.IsInf(v, 1) {
+		return "free"
+	}
 	units := []string{"B/s", "KB/s", "MB/s", "GB/s", "TB/s"}
 	i := 0
 	for v >= 1024 && i < len(units)-1 {
@@ -61,49 +165,243 @@ func formatBandwidth(v float64) string {
 	return fmt.Sprintf("%.2f%s", v, units[i])
 }
 
-func formatMilliseconds(d time.Duration) string {
+// FormatMilliseconds 以毫秒显示时长，未测得时显示 N/A
+func FormatMilliseconds(d time.Duration) string {
 	if d <= 0 {
 		return "N/A"
 	}
 	return fmt.Sprintf("%.2fms", float64(d.Milliseconds()))
 }
 
-func formatDelay(d uint16) string {
-	if d == 9999 {
+func formatUDP(r Result) string {
+	if r.UDPStatus != UDPStatusOK {
+		return strings.ToUpper(r.UDPStatus)
+	}
+	return fmt.Sprintf("%s/%.0f%%", FormatMilliseconds(r.UDPRTT), r.UDPLoss*100)
+}
+
+// hasUDPResults 判断是否有结果进行过 UDP 测试，用于决定是否显示 UDP 列
+func hasUDPResults(results []Result) bool {
+	for _, res := range results {
+		if res.UDPStatus != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// formatCountry 在出口国家与节点名称声明的地区不一致时加以标记
+func formatCountry(r Result) string {
+	if r.RegionMismatch {
+		return fmt.Sprintf("%s (claims %s)", r.Country, r.ClaimedRegion)
+	}
+	return r.Country
+}
+
+// FormatASN 以 AS13335 的形式显示 ASN，没有查到时为空
+func FormatASN(asn uint32) string {
+	if asn == 0 {
+		return ""
+	}
+	return fmt.Sprintf("AS%d", asn)
+}
+
+func formatEntry(r Result) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", r.EntryCountry, FormatASN(r.EntryASN)))
+}
+
+func formatExitASN(r Result) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", FormatASN(r.ASN), r.ExitType))
+}
+
+// hasGeoIPResults 判断是否使用本地数据库补充过信息，用于决定是否显示入口与 ASN 列
+func hasGeoIPResults(results []Result) bool {
+	for _, res := range results {
+		if res.EntryIP != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMultipliers 判断是否有节点标注了非 1 的流量倍率（包括免流量），用于决定是否显示倍率列
+func hasMultipliers(results []Result) bool {
+	for _, res := range results {
+		if res.Multiplier != 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVariableTestSize 判断各节点的下载大小是否不同（自适应模式）
+func hasVariableTestSize(results []Result) bool {
+	for _, res := range results {
+		if res.TestSize != 0 && res.TestSize != results[0].TestSize {
+			return true
+		}
+	}
+	return false
+}
+
+func formatTestSize(size int64) string {
+	if size <= 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%.1fMB", float64(size)/1024/1024)
+}
+
+func formatMultiplier(m float64) string {
+	if m == 0 {
+		return "free"
+	}
+	return "x" + strconv.FormatFloat(m, 'f', -1, 64)
+}
+
+func hasNATResults(results []Result) bool {
+	for _, res := range results {
+		if res.NATType != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func formatJitter(r Result) string {
+	if !delayOK(&r) {
+		return "N/A"
+	}
+	return fmt.Sprintf("%d", r.Jitter)
+}
+
+// hasStatus 判断是否按阈值策略判定过结果，用于决定是否显示状态列
+func hasStatus(results []Result) bool {
+	for _, res := range results {
+		if res.Status != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func hasLoadedLatency(results []Result) bool {
+	for _, res := range results {
+		if res.LoadedLatency > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// formatLoadedLatency 显示空闲与负载下的延迟及其差值
+func formatLoadedLatency(r Result) string {
+	if r.IdleLatency <= 0 || r.LoadedLatency <= 0 {
 		return "N/A"
 	}
-	return fmt.Sprintf("%d", d)
+	return fmt.Sprintf("%s / %s (%+dms)", FormatMilliseconds(r.IdleLatency), FormatMilliseconds(r.LoadedLatency), r.Bufferbloat().Milliseconds())
 }
 
-func SortResults(results []Result, sortBy string) {
-	switch sortBy {
-	case "b", "bandwidth":
-		sort.Slice(results, func(i, j int) bool {
-			return results[i].Bandwidth > results[j].Bandwidth
-		})
-	case "t", "ttfb":
-		sort.Slice(results, func(i, j int) bool {
-			return results[i].TTFB < results[j].TTFB
-		})
-	case "d", "delay":
-		sort.Slice(results, func(i, j int) bool {
-			return results[i].Delay < results[j].Delay
-		})
+func hasSweep(results []Result) bool {
+	for _, res := range results {
+		if res.BestStreams > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// formatSweep 显示单连接带宽，以及最佳连接数下的带宽
+func formatSweep(r Result) string {
+	if r.BestStreams == 0 {
+		return "N/A"
 	}
+	return fmt.Sprintf("%s / %d: %s", FormatBandwidth(r.SingleStreamBandwidth), r.BestStreams, FormatBandwidth(r.Bandwidth))
 }
 
-func DisplayDelayResult(results []Result) {
+// skippedOr 对因流量上限跳过的节点显示 skipped，否则显示 value
+func skippedOr(r Result, value string) string {
+	if r.Skipped {
+		return "skipped"
+	}
+	return value
+}
+
+// FormatDelay 显示延迟测试的结果，未测试或超时时显示 N/A
+func FormatDelay(d uint16) string {
+	if d == 0 || d == 9999 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%dms", d)
+}
+
+// DisplayTraffic 输出整次测试经过代理的流量，以及因流量上限跳过的节点数
+func DisplayTraffic(results []Result, total int64) {
+	fmt.Printf("\nTraffic used: %.2fMB\n", float64(total)/1024/1024)
+	skipped := 0
+	for _, res := range results {
+		if res.Skipped {
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		fmt.Printf("%d nodes skipped after reaching the traffic limit\n", skipped)
+	}
+}
+
+// DisplayDelayResult 显示延迟测试结果，sortBy 为空时按延迟排序
+func DisplayDelayResult(results []Result, sortBy string) {
 	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Node", "Delay(ms)", "IP", "Country"})
+	header := []string{"Node", "Delay", "IP", "Country"}
+	showJitter := hasJitter(results)
+	if showJitter {
+		header = append(header, "Jitter(ms)")
+	}
+	header = append(header, "Score")
+	showGeoIP := hasGeoIPResults(results)
+	if showGeoIP {
+		header = append(header, "ASN", "Entry")
+	}
+	showUDP := hasUDPResults(results)
+	if showUDP {
+		header = append(header, "UDP")
+	}
+	showNAT := hasNATResults(results)
+	if showNAT {
+		header = append(header, "NAT")
+	}
+	showStatus := hasStatus(results)
+	if showStatus {
+		header = append(header, "Status")
+	}
+	table.SetHeader(header)
 
-	SortResults(results, "delay")
+	if sortBy == "" {
+		sortBy = "delay"
+	}
+	SortResults(results, sortBy)
 
 	for _, res := range results {
 		data := []string{
 			formatName(res.Name),
-			formatDelay(res.Delay),
+			skippedOr(res, FormatDelay(res.Delay)),
 			fmt.Sprintf("%v", res.OutBoundIp),
-			fmt.Sprintf("%v", res.Country),
+			formatCountry(res),
+		}
+		if showJitter {
+			data = append(data, formatJitter(res))
+		}
+		data = append(data, formatScore(res.Score))
+		if showGeoIP {
+			data = append(data, formatExitASN(res), formatEntry(res))
+		}
+		if showUDP {
+			data = append(data, formatUDP(res))
+		}
+		if showNAT {
+			data = append(data, res.NATType)
+		}
+		if showStatus {
+			data = append(data, strings.ToUpper(res.Status))
 		}
 		table.Append(data)
 	}
@@ -117,20 +415,112 @@ func DisplayResults(results []Result, sortedBy string) {
 	} else {
 		fmt.Printf("\nResults:\n")
 	}
+	WriteTable(os.Stdout, results)
+}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{"Node", "Bandwidth", "Latency", "IP", "Country"})
+// WriteTable 将带宽测试结果以表格形式写入 w，只显示有数据的附加列
+func WriteTable(w io.Writer, results []Result) {
+	table := tablewriter.NewWriter(w)
+	header := []string{"Node", "Bandwidth", "Latency", "IP", "Country", "Score"}
+	showRounds := hasRoundStats(results)
+	if showRounds {
+		header = append(header, "Mean ±95% CI", "Latency Mean ±95% CI")
+	}
+	showSweep := hasSweep(results)
+	if showSweep {
+		header = append(header, "1 Stream / Best")
+	}
+	showLoaded := hasLoadedLatency(results)
+	if showLoaded {
+		header = append(header, "Idle / Loaded")
+	}
+	showTestSize := hasVariableTestSize(results)
+	if showTestSize {
+		header = append(header, "Size")
+	}
+	showMultiplier := hasMultipliers(results)
+	if showMultiplier {
+		header = append(header, "Multiplier", "Cost-adjusted")
+	}
+	showGeoIP := hasGeoIPResults(results)
+	if showGeoIP {
+		header = append(header, "ASN", "Entry")
+	}
+	showUDP := hasUDPResults(results)
+	if showUDP {
+		header = append(header, "UDP")
+	}
+	showNAT := hasNATResults(results)
+	if showNAT {
+		header = append(header, "NAT")
+	}
+	showStatus := hasStatus(results)
+	if showStatus {
+		header = append(header, "Status")
+	}
+	table.SetHeader(header)
 
 	for _, res := range results {
 		data := []string{
 			formatName(res.Name),
-			fmt.Sprintf("%v", formatBandwidth(res.Bandwidth)),
-			fmt.Sprintf("%v", formatMilliseconds(res.TTFB)),
+			skippedOr(res, FormatBandwidth(res.Bandwidth)),
+			fmt.Sprintf("%v", FormatMilliseconds(res.TTFB)),
 			fmt.Sprintf("%v", res.OutBoundIp),
-			fmt.Sprintf("%v", res.Country),
+			formatCountry(res),
+			formatScore(res.Score),
+		}
+		if showRounds {
+			data = append(data, formatBandwidthStats(res), formatTTFBStats(res))
+		}
+		if showSweep {
+			data = append(data, formatSweep(res))
+		}
+		if showLoaded {
+			data = append(data, formatLoadedLatency(res))
+		}
+		if showTestSize {
+			data = append(data, formatTestSize(res.TestSize))
+		}
+		if showMultiplier {
+			data = append(data, formatMultiplier(res.Multiplier), FormatBandwidth(res.CostAdjustedBandwidth()))
+		}
+		if showGeoIP {
+			data = append(data, formatExitASN(res), formatEntry(res))
+		}
+		if showUDP {
+			data = append(data, formatUDP(res))
+		}
+		if showNAT {
+			data = append(data, res.NATType)
+		}
+		if showStatus {
+			data = append(data, strings.ToUpper(res.Status))
 		}
 		table.Append(data)
 	}
 
 	table.Render()
 }
+
+// MergeByName 以 updates 中的结果替换 base 中同名节点的结果，其余节点保持不变，新节点追加在末尾。
+// 用于把只测试了部分节点的运行合并到最近一次的完整结果中
+func MergeByName(base, updates []Result) []Result {
+	merged := make([]Result, 0, len(base)+len(updates))
+	index := make(map[string]int, len(updates))
+	for i, res := range updates {
+		index[res.Name] = i
+	}
+	for _, res := range base {
+		if i, ok := index[res.Name]; ok {
+			res = updates[i]
+			delete(index, res.Name)
+		}
+		merged = append(merged, res)
+	}
+	for _, res := range updates {
+		if _, ok := index[res.Name]; ok {
+			merged = append(merged, res)
+		}
+	}
+	return merged
+}
@@ -1,7 +1,9 @@
 package result
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 )
 
 func TestFormatName(t *testing.T) {
@@ -42,3 +44,168 @@ func TestFormatName(t *testing.T) {
 		}
 	}
 }
+
+func TestAssignClusters(t *testing.T) {
+	results := []Result{
+		// 不同域名解析到同一入口 IP；没有入口 IP 时按主机名分组，不区分端口
+		{Name: "HK 01", OutBoundIp: "1.1.1.1", Server: "a.example.com:443", EntryIP: "3.3.3.3"},
+		{Name: "HK 02", OutBoundIp: "1.1.1.1", Server: "b.example.com:443", EntryIP: "4.4.4.4"},
+		{Name: "JP 01", OutBoundIp: "2.2.2.2", Server: "d.example.com:8443"},
+		{Name: "US 01", OutBoundIp: "1.1.1.1", Server: "c.example.com:443", EntryIP: "3.3.3.3"},
+		{Name: "SG 01", OutBoundIp: "", Server: "d.example.com:443"},
+	}
+
+	exit, entry := AssignClusters(results)
+	if len(exit) != 1 || exit[0].Key != "1.1.1.1" || len(exit[0].Names) != 3 {
+		t.Errorf("exit clusters = %+v; want one cluster of 3 nodes on 1.1.1.1", exit)
+	}
+	if len(entry) != 2 || entry[0].Key != "3.3.3.3" || entry[1].Key != "d.example.com" {
+		t.Errorf("entry clusters = %+v; want clusters on 3.3.3.3 and d.example.com", entry)
+	}
+
+	expected := map[string][2]int{
+		"HK 01": {1, 1},
+		"HK 02": {1, 0},
+		"JP 01": {0, 2},
+		"US 01": {1, 1},
+		"SG 01": {0, 2},
+	}
+	for _, res := range results {
+		if got := [2]int{res.ExitCluster, res.EntryCluster}; got != expected[res.Name] {
+			t.Errorf("%s clusters = %v; want %v", res.Name, got, expected[res.Name])
+		}
+	}
+}
+
+func TestSortResults(t *testing.T) {
+	results := []Result{
+		{Name: "a", Country: "JP", Bandwidth: 10, TTFB: 300},
+		{Name: "b", Country: "HK", Bandwidth: -1, TTFB: -1},
+		{Name: "c", Country: "HK", Bandwidth: 20, TTFB: 200},
+		{Name: "d", Country: "", Bandwidth: 30, TTFB: 100},
+		{Name: "e", Country: "HK", Bandwidth: 20, TTFB: 100},
+	}
+
+	tests := []struct {
+		spec     string
+		expected string
+	}{
+		{spec: "b", expected: "dceab"},
+		{spec: "t", expected: "decab"},
+		{spec: "+bandwidth", expected: "acedb"},
+		{spec: "country,-bandwidth,ttfb", expected: "ecbad"},
+	}
+
+	for _, test := range tests {
+		sorted := append([]Result(nil), results...)
+		SortResults(sorted, test.spec)
+		names := ""
+		for _, res := range sorted {
+			names += res.Name
+		}
+		if names != test.expected {
+			t.Errorf("SortResults(%q) = %s; want %s", test.spec, names, test.expected)
+		}
+	}
+
+	// 免流量节点按折算带宽排在最前，之间按实际带宽排序
+	costs := []Result{
+		{Name: "a", Bandwidth: 30, Multiplier: 2},
+		{Name: "b", Bandwidth: 10, Multiplier: 0},
+		{Name: "c", Bandwidth: 20, Multiplier: 1},
+		{Name: "d", Bandwidth: 20, Multiplier: 0},
+		{Name: "e", Bandwidth: 0, Multiplier: 0},
+	}
+	SortResults(costs, "c")
+	names := ""
+	for _, res := range costs {
+		names += res.Name
+	}
+	if names != "dbcae" {
+		t.Errorf("SortResults(c) = %s; want dbcae", names)
+	}
+
+	// 免流量节点的倍率 0 需要保存到结果文件中
+	data, err := json.Marshal(Result{Name: "b", Multiplier: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := decoded["multiplier"]; !ok || m != 0.0 {
+		t.Errorf("multiplier of a free node = %v, %v; want 0, true", m, ok)
+	}
+
+	if _, err := ParseSortSpec("country,-speed"); err == nil {
+		t.Errorf("ParseSortSpec with an unknown field should fail")
+	}
+}
+
+func TestScoreResults(t *testing.T) {
+	results := []Result{
+		{Name: "fast", Bandwidth: 100, TTFB: 100 * time.Millisecond, SuccessRate: 1},
+		{Name: "slow", Bandwidth: 50, TTFB: 100 * time.Millisecond, SuccessRate: 1},
+		{Name: "failed", Bandwidth: -1, TTFB: -1},
+	}
+
+	weights, err := ParseScoreWeights("bandwidth=1,ttfb=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ScoreResults(results, weights)
+
+	if results[0].Score != 100 {
+		t.Errorf("best result score = %v; want 100", results[0].Score)
+	}
+	if results[1].Score != 75 {
+		t.Errorf("half bandwidth score = %v; want 75", results[1].Score)
+	}
+	if results[2].Score != 0 {
+		t.Errorf("failed result score = %v; want 0", results[2].Score)
+	}
+
+	if _, err := ParseScoreWeights("bandwidth=1,speed=2"); err == nil {
+		t.Errorf("ParseScoreWeights with an unknown metric should fail")
+	}
+}
+
+func TestAggregateRounds(t *testing.T) {
+	rounds := [][]Result{
+		{{Name: "a", Bandwidth: 100, TTFB: 100 * time.Millisecond, SuccessRate: 1, OutBoundIp: "1.1.1.1"}},
+		{{Name: "a", Bandwidth: -1, TTFB: -1}},
+		{{Name: "a", Bandwidth: 200, TTFB: 300 * time.Millisecond, SuccessRate: 1, OutBoundIp: "2.2.2.2"}},
+	}
+	results := AggregateRounds(rounds)
+	if len(results) != 1 {
+		t.Fatalf("AggregateRounds() returned %d results; want 1", len(results))
+	}
+
+	res := results[0]
+	if res.Bandwidth != 150 || res.TTFB != 200*time.Millisecond {
+		t.Errorf("aggregated bandwidth, ttfb = %v, %v; want 150, 200ms", res.Bandwidth, res.TTFB)
+	}
+	if res.OutBoundIp != "2.2.2.2" || len(res.Rounds) != 3 {
+		t.Errorf("aggregated ip, rounds = %v, %d; want 2.2.2.2, 3", res.OutBoundIp, len(res.Rounds))
+	}
+	if s := res.BandwidthStats; s.N != 2 || s.Median != 150 || s.CI95 < 635 || s.CI95 > 636 {
+		t.Errorf("bandwidth stats = %+v", *s)
+	}
+	if got := formatTTFBStats(res); got != "200ms ±1271ms" {
+		t.Errorf("formatTTFBStats() = %s; want the mean with its CI", got)
+	}
+
+	// 只有一轮成功时不显示置信区间，全部失败时只显示 N/A
+	rounds = [][]Result{
+		{{Name: "one", Bandwidth: 2048, TTFB: 100 * time.Millisecond}, {Name: "none", Bandwidth: -1, TTFB: -1}},
+		{{Name: "one", Bandwidth: -1, TTFB: -1}, {Name: "none", Bandwidth: -1, TTFB: -1}},
+	}
+	results = AggregateRounds(rounds)
+	if got := formatBandwidthStats(results[0]); got != "2.00KB/s" {
+		t.Errorf("formatBandwidthStats(one successful round) = %s; want 2.00KB/s", got)
+	}
+	if got := formatBandwidthStats(results[1]) + " " + formatTTFBStats(results[1]); got != "N/A N/A" {
+		t.Errorf("stats of failed rounds = %s; want N/A N/A", got)
+	}
+}
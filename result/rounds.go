@@ -0,0 +1,168 @@
+package result
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Round 为多轮测试中某一轮的原始结果
+type Round struct {
+	Bandwidth float64       `json:"bandwidth" yaml:"bandwidth"`
+	TTFB      time.Duration `json:"ttfb" yaml:"ttfb"`
+}
+
+// Stats 为多轮测试中某项指标的统计结果，CI95 为均值 95% 置信区间的半宽
+type Stats struct {
+	N      int     `json:"n" yaml:"n"`
+	Mean   float64 `json:"mean" yaml:"mean"`
+	Median float64 `json:"median" yaml:"median"`
+	StdDev float64 `json:"stddev" yaml:"stddev"`
+	CI95   float64 `json:"ci95" yaml:"ci95"`
+}
+
+// tCritical 为自由度 1~30 时双侧 95% 置信度的 t 分布临界值，更大的自由度使用 1.96
+var tCritical = []float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// NewStats 计算均值、中位数、样本标准差与均值的 95% 置信区间
+func NewStats(values []float64) Stats {
+	s := Stats{N: len(values)}
+	if s.N == 0 {
+		return s
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	mid := s.N / 2
+	if s.N%2 == 0 {
+		s.Median = (sorted[mid-1] + sorted[mid]) / 2
+	} else {
+		s.Median = sorted[mid]
+	}
+
+	for _, v := range values {
+		s.Mean += v
+	}
+	s.Mean /= float64(s.N)
+	if s.N < 2 {
+		return s
+	}
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - s.Mean) * (v - s.Mean)
+	}
+	s.StdDev = math.Sqrt(variance / float64(s.N-1))
+
+	t := 1.96
+	if df := s.N - 1; df <= len(tCritical) {
+		t = tCritical[df-1]
+	}
+	s.CI95 = t * s.StdDev / math.Sqrt(float64(s.N))
+	return s
+}
+
+// AggregateRounds 合并多轮测试的结果：带宽与 TTFB 取成功各轮的均值并附带统计结果，
+// 其他字段取最后一次成功的结果，各轮的原始值保存在 Rounds 中。
+func AggregateRounds(rounds [][]Result) []Result {
+	order := make([]string, 0)
+	runs := make(map[string][]Result)
+	for _, results := range rounds {
+		for _, res := range results {
+			if _, ok := runs[res.Name]; !ok {
+				order = append(order, res.Name)
+			}
+			runs[res.Name] = append(runs[res.Name], res)
+		}
+	}
+
+	aggregated := make([]Result, 0, len(order))
+	for _, name := range order {
+		aggregated = append(aggregated, aggregate(runs[name]))
+	}
+	return aggregated
+}
+
+func aggregate(runs []Result) Result {
+	agg := runs[len(runs)-1]
+	bandwidths := make([]float64, 0, len(runs))
+	ttfbs := make([]float64, 0, len(runs))
+	tested := 0
+	successRate, traffic := 0.0, int64(0)
+
+	agg.Rounds = make([]Round, 0, len(runs))
+	for _, res := range runs {
+		traffic += res.Traffic
+		if res.Skipped {
+			continue
+		}
+		tested++
+		successRate += res.SuccessRate
+		agg.Rounds = append(agg.Rounds, Round{Bandwidth: res.Bandwidth, TTFB: res.TTFB})
+		if res.Bandwidth > 0 {
+			bandwidths = append(bandwidths, res.Bandwidth)
+			ttfbs = append(ttfbs, float64(res.TTFB)/float64(time.Millisecond))
+			agg = withRounds(res, agg)
+		} else if agg.Skipped {
+			agg = withRounds(res, agg)
+		}
+	}
+
+	agg.Traffic = traffic
+	if tested == 0 {
+		return agg
+	}
+	agg.SuccessRate = successRate / float64(tested)
+	if len(bandwidths) > 0 {
+		bs, ts := NewStats(bandwidths), NewStats(ttfbs)
+		agg.Bandwidth = bs.Mean
+		agg.TTFB = time.Duration(ts.Mean * float64(time.Millisecond))
+		agg.BandwidthStats, agg.TTFBStats = &bs, &ts
+	}
+	return agg
+}
+
+// withRounds 以 res 作为合并结果的基础，保留已收集的各轮原始值
+func withRounds(res Result, agg Result) Result {
+	res.Rounds = agg.Rounds
+	return res
+}
+
+func hasRoundStats(results []Result) bool {
+	for _, res := range results {
+		if res.BandwidthStats != nil && res.BandwidthStats.N > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// formatBandwidthStats 显示带宽的均值与均值的 95% 置信区间，只有一轮成功时只显示均值
+func formatBandwidthStats(r Result) string {
+	s := r.BandwidthStats
+	if s == nil {
+		return "N/A"
+	}
+	if s.N < 2 {
+		return FormatBandwidth(s.Mean)
+	}
+	return fmt.Sprintf("%s ±%s", FormatBandwidth(s.Mean), FormatBandwidth(s.CI95))
+}
+
+// formatTTFBStats 显示 TTFB 的均值与均值的 95% 置信区间（ms），只有一轮成功时只显示均值
+func formatTTFBStats(r Result) string {
+	s := r.TTFBStats
+	if s == nil {
+		return "N/A"
+	}
+	if s.N < 2 {
+		return fmt.Sprintf("%.0fms", s.Mean)
+	}
+	return fmt.Sprintf("%.0fms ±%.0fms", s.Mean, s.CI95)
+}
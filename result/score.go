@@ -0,0 +1,136 @@
+package result
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScoreWeights 为综合评分中各项指标的权重
+type ScoreWeights map[string]float64
+
+var DefaultScoreWeights = ScoreWeights{
+	"bandwidth": 0.4,
+	"ttfb":      0.2,
+	"delay":     0.2,
+	"jitter":    0.1,
+	"success":   0.1,
+}
+
+// ParseScoreWeights 解析形如 "bandwidth=0.5,ttfb=0.3,success=0.2" 的权重，未列出的指标权重为 0
+func ParseScoreWeights(spec string) (ScoreWeights, error) {
+	if spec == "" {
+		return DefaultScoreWeights, nil
+	}
+	weights := ScoreWeights{}
+	for _, pair := range strings.Split(spec, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid score weight: %s", pair)
+		}
+		if _, known := DefaultScoreWeights[key]; !known {
+			return nil, fmt.Errorf("unknown score metric: %s", key)
+		}
+		w, err := strconv.ParseFloat(value, 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid score weight: %s", pair)
+		}
+		weights[key] = w
+	}
+	return weights, nil
+}
+
+// scoreMetric 返回结果在某项指标上的取值，ok 为 false 表示未测得
+type scoreMetric struct {
+	value          func(r *Result) (float64, bool)
+	higherIsBetter bool
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func delayOK(r *Result) bool {
+	return r.Delay > 0 && r.Delay != 9999
+}
+
+var scoreMetrics = map[string]scoreMetric{
+	"bandwidth": {func(r *Result) (float64, bool) { return r.Bandwidth, r.Bandwidth > 0 }, true},
+	"ttfb":      {func(r *Result) (float64, bool) { return milliseconds(r.TTFB), r.TTFB > 0 }, false},
+	"delay":     {func(r *Result) (float64, bool) { return float64(r.Delay), delayOK(r) }, false},
+	"jitter":    {func(r *Result) (float64, bool) { return float64(r.Jitter), delayOK(r) }, false},
+	"success":   {func(r *Result) (float64, bool) { return r.SuccessRate, r.SuccessRate > 0 }, true},
+}
+
+// ScoreResults 计算 0~100 的综合评分并写入 Score。
+// 每项指标按本次结果中的最优值归一化：越大越好的指标为 v/max，越小越好的指标为 (min+1)/(v+1)。
+// 所有结果都未测得的指标不参与评分，单个结果未测得的指标记 0 分。
+func ScoreResults(results []Result, weights ScoreWeights) {
+	type bound struct {
+		best     float64
+		measured bool
+	}
+	bounds := make(map[string]*bound)
+
+	for name, metric := range scoreMetrics {
+		if weights[name] <= 0 {
+			continue
+		}
+		b := &bound{}
+		for i := range results {
+			v, ok := metric.value(&results[i])
+			if !ok {
+				continue
+			}
+			if !b.measured || (metric.higherIsBetter && v > b.best) || (!metric.higherIsBetter && v < b.best) {
+				b.best = v
+			}
+			b.measured = true
+		}
+		// jitter 只有多次探测时才有意义
+		if name == "jitter" && b.measured {
+			b.measured = hasJitter(results)
+		}
+		if b.measured {
+			bounds[name] = b
+		}
+	}
+
+	for i := range results {
+		total, weightSum := 0.0, 0.0
+		for name, b := range bounds {
+			metric := scoreMetrics[name]
+			weightSum += weights[name]
+			v, ok := metric.value(&results[i])
+			if !ok {
+				continue
+			}
+			if metric.higherIsBetter {
+				total += weights[name] * v / b.best
+			} else {
+				total += weights[name] * (b.best + 1) / (v + 1)
+			}
+		}
+		results[i].Score = 0
+		if weightSum > 0 {
+			results[i].Score = 100 * total / weightSum
+		}
+	}
+}
+
+func hasJitter(results []Result) bool {
+	for _, res := range results {
+		if res.Jitter > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func formatScore(score float64) string {
+	if score <= 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%.1f", score)
+}
@@ -0,0 +1,35 @@
+package result
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// DisplaySoakResults 按在线时间比例从高到低输出长时间稳定性测试的结果
+func DisplaySoakResults(results []Result) {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].SoakUptime != results[j].SoakUptime {
+			return results[i].SoakUptime > results[j].SoakUptime
+		}
+		return results[i].SoakResets+results[i].SoakStalls < results[j].SoakResets+results[j].SoakStalls
+	})
+
+	fmt.Printf("\nSoak test results:\n")
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Node", "Duration", "Uptime", "Stalls", "Resets", "Reconnects"})
+	for _, res := range results {
+		table.Append([]string{
+			formatName(res.Name),
+			res.SoakDuration.Round(time.Second).String(),
+			fmt.Sprintf("%.1f%%", res.SoakUptime*100),
+			fmt.Sprintf("%d", res.SoakStalls),
+			fmt.Sprintf("%d", res.SoakResets),
+			fmt.Sprintf("%d", res.SoakReconnects),
+		})
+	}
+	table.Render()
+}
@@ -0,0 +1,155 @@
+package result
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// SortKey 为排序规则中的一个字段
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// sortFields 为支持的排序字段及其默认方向（true 为降序）
+var sortFields = map[string]bool{
+	"bandwidth": true,
+	"ttfb":      false,
+	"delay":     false,
+	"jitter":    false,
+	"cost":      true,
+	"score":     true,
+	"country":   false,
+	"name":      false,
+}
+
+var sortAliases = map[string]string{
+	"b": "bandwidth",
+	"t": "ttfb",
+	"d": "delay",
+	"j": "jitter",
+	"c": "cost",
+	"s": "score",
+}
+
+// ParseSortSpec 解析逗号分隔的多字段排序规则，例如 "country,-bandwidth,ttfb"。
+// 字段前的 - 表示降序，+ 表示升序，不带前缀时使用字段的默认方向（带宽、评分降序，延迟升序）。
+func ParseSortSpec(spec string) ([]SortKey, error) {
+	keys := make([]SortKey, 0)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		prefix := item[0]
+		if prefix == '-' || prefix == '+' {
+			item = item[1:]
+		}
+		field := strings.ToLower(item)
+		if alias, ok := sortAliases[field]; ok {
+			field = alias
+		}
+		desc, ok := sortFields[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown sort field: %s", item)
+		}
+
+		switch prefix {
+		case '-':
+			desc = true
+		case '+':
+			desc = false
+		}
+		keys = append(keys, SortKey{Field: field, Desc: desc})
+	}
+	return keys, nil
+}
+
+// sortValue 返回结果在某个字段上的取值，ok 为 false 表示该项测试失败或未测试
+func sortValue(r *Result, field string) (value float64, ok bool) {
+	switch field {
+	case "bandwidth":
+		return r.Bandwidth, r.Bandwidth > 0
+	case "ttfb":
+		return float64(r.TTFB), r.TTFB > 0
+	case "delay":
+		return float64(r.Delay), r.Delay > 0 && r.Delay != 9999
+	case "jitter":
+		return float64(r.Jitter), r.Delay > 0 && r.Delay != 9999
+	case "cost":
+		return r.CostAdjustedBandwidth(), r.Bandwidth > 0
+	case "score":
+		return r.Score, r.Score > 0
+	}
+	return 0, false
+}
+
+func compareStrings(a, b string) int {
+	// 空字符串排在最后
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	case a < b:
+		return -1
+	}
+	return 1
+}
+
+// compare 按单个字段比较两个结果，失败的结果无论升降序都排在最后
+func compare(a, b *Result, key SortKey) int {
+	switch key.Field {
+	case "country", "name":
+		x, y := a.Country, b.Country
+		if key.Field == "name" {
+			x, y = a.Name, b.Name
+		}
+		c := compareStrings(x, y)
+		if key.Desc && x != "" && y != "" {
+			c = -c
+		}
+		return c
+	}
+
+	x, okA := sortValue(a, key.Field)
+	y, okB := sortValue(b, key.Field)
+	switch {
+	case !okA && !okB:
+		return 0
+	case !okA:
+		return 1
+	case !okB:
+		return -1
+	case x == y:
+		// 免流量节点的折算带宽均为 +Inf，之间按实际带宽比较
+		if key.Field == "cost" && math.IsInf(x, 1) {
+			return compare(a, b, SortKey{Field: "bandwidth", Desc: key.Desc})
+		}
+		return 0
+	case (x < y) != key.Desc:
+		return -1
+	}
+	return 1
+}
+
+// SortResults 按排序规则对结果进行稳定排序，无法解析的规则会被忽略
+func SortResults(results []Result, sortBy string) {
+	keys, err := ParseSortSpec(sortBy)
+	if err != nil || len(keys) == 0 {
+		return
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		for _, key := range keys {
+			if c := compare(&results[i], &results[j], key); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,47 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	base := time.Date(2024, 1, 31, 10, 7, 30, 0, time.UTC) // 周三
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/10 * * * *", time.Date(2024, 1, 31, 10, 10, 0, 0, time.UTC)},
+		{"0 */6 * * *", time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, 2, 1, 2, 30, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		// 日期与星期都受限时满足其一即可
+		{"0 0 15 * sat", time.Date(2024, 2, 3, 0, 0, 0, 0, time.UTC)},
+		{"5/20 10 * * *", time.Date(2024, 1, 31, 10, 25, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@every 90s", time.Date(2024, 1, 31, 10, 9, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(base); !got.Equal(tt.want) {
+			t.Errorf("Parse(%q).Next() = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+
+	s, _ := Parse("0 0 30 2 *")
+	if got := s.Next(base); !got.IsZero() {
+		t.Errorf("impossible schedule Next() = %s, want zero", got)
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "@every 1ms", "* * * foo *"} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) expected error", expr)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package stun
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// NAT 映射与过滤行为，参见 RFC 4787 / RFC 5780
+const (
+	EndpointIndependent     = "endpoint-independent"
+	AddressDependent        = "address-dependent"
+	AddressAndPortDependent = "address-and-port-dependent"
+	Unknown                 = "unknown"
+)
+
+const (
+	retransmissions          = 3
+	defaultRetransmitTimeout = 500 * time.Millisecond
+)
+
+var errNoResponse = errors.New("no stun response")
+
+// Behavior 为一次 RFC 5780 行为探测的结果
+type Behavior struct {
+	MappedAddr *net.UDPAddr
+	Mapping    string
+	Filtering  string
+}
+
+// NATType 将映射与过滤行为归纳为传统的 NAT 类型名称
+func (b Behavior) NATType() string {
+	switch {
+	case b.Mapping == Unknown || b.Mapping == "":
+		return Unknown
+	case b.Mapping != EndpointIndependent:
+		return "symmetric"
+	case b.Filtering == EndpointIndependent:
+		return "full-cone"
+	case b.Filtering == AddressDependent:
+		return "restricted-cone"
+	case b.Filtering == AddressAndPortDependent:
+		return "port-restricted-cone"
+	}
+	return Unknown
+}
+
+// Client 通过任意 net.PacketConn（例如代理的 UDP 转发）发送 STUN 请求
+type Client struct {
+	Conn net.PacketConn
+	// NewConn 打开一个未使用过的本地端口，用于过滤行为测试。
+	// RFC 5780 要求过滤测试不能复用映射测试中已向备用地址发送过数据的端口，
+	// 为 nil 时无法测试过滤行为
+	NewConn func(ctx context.Context) (net.PacketConn, error)
+	// RetransmitTimeout 为单次请求等待响应的时间
+	RetransmitTimeout time.Duration
+}
+
+type bindingResponse struct {
+	mapped *net.UDPAddr
+	other  *net.UDPAddr
+}
+
+func (c *Client) binding(ctx context.Context, conn net.PacketConn, server *net.UDPAddr, change uint32) (*bindingResponse, error) {
+	timeout := c.RetransmitTimeout
+	if timeout <= 0 {
+		timeout = defaultRetransmitTimeout
+	}
+
+	req := newBindingRequest(change)
+	payload := req.encode()
+	buf := make([]byte, 1500)
+
+	for i := 0; i < retransmissions; i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := conn.WriteTo(payload, server); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			// 响应可能来自另一个地址，只按事务 ID 匹配
+			resp, err := decode(buf[:n])
+			if err != nil || resp.typ != typeBindingResponse || !bytes.Equal(resp.txID[:], req.txID[:]) {
+				continue
+			}
+			return parseBindingResponse(resp)
+		}
+	}
+	return nil, errNoResponse
+}
+
+func parseBindingResponse(resp *message) (*bindingResponse, error) {
+	r := &bindingResponse{}
+	var err error
+	if value := resp.get(attrXorMappedAddress); value != nil {
+		r.mapped, err = decodeAddress(value, resp.txID, true)
+	} else if value := resp.get(attrMappedAddress); value != nil {
+		r.mapped, err = decodeAddress(value, resp.txID, false)
+	} else {
+		err = errInvalidMessage
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if value := resp.get(attrOtherAddress); value != nil {
+		r.other, _ = decodeAddress(value, resp.txID, false)
+	}
+	return r, nil
+}
+
+// Discover 按 RFC 5780 第 4.3、4.4 节测试映射与过滤行为，ctx 结束时停止测试。
+// 服务器未返回 OTHER-ADDRESS 时只能得到映射地址，行为为 Unknown。
+func (c *Client) Discover(ctx context.Context, server *net.UDPAddr) (Behavior, error) {
+	b := Behavior{Mapping: Unknown, Filtering: Unknown}
+
+	r1, err := c.binding(ctx, c.Conn, server, 0)
+	if err != nil {
+		return b, err
+	}
+	b.MappedAddr = r1.mapped
+	if r1.other == nil {
+		return b, nil
+	}
+
+	// 单 IP 的服务器只能改变端口
+	canChangeIP := !r1.other.IP.Equal(server.IP)
+
+	// 映射行为
+	if canChangeIP {
+		r2, err := c.binding(ctx, c.Conn, &net.UDPAddr{IP: r1.other.IP, Port: server.Port}, 0)
+		switch {
+		case err != nil:
+		case sameAddr(r2.mapped, r1.mapped):
+			b.Mapping = EndpointIndependent
+		default:
+			r3, err := c.binding(ctx, c.Conn, r1.other, 0)
+			if err == nil {
+				if sameAddr(r3.mapped, r2.mapped) {
+					b.Mapping = AddressDependent
+				} else {
+					b.Mapping = AddressAndPortDependent
+				}
+			}
+		}
+	} else {
+		r3, err := c.binding(ctx, c.Conn, &net.UDPAddr{IP: server.IP, Port: r1.other.Port}, 0)
+		if err == nil {
+			if sameAddr(r3.mapped, r1.mapped) {
+				b.Mapping = EndpointIndependent
+			} else {
+				b.Mapping = AddressAndPortDependent
+			}
+		}
+	}
+
+	if c.NewConn == nil {
+		return b, ctx.Err()
+	}
+	conn, err := c.NewConn(ctx)
+	if err != nil {
+		return b, ctx.Err()
+	}
+	defer conn.Close()
+	b.Filtering = c.filtering(ctx, conn, server, canChangeIP)
+	return b, ctx.Err()
+}
+
+// filtering 在新端口上测试过滤行为，此前该端口只向 server 发送过数据
+func (c *Client) filtering(ctx context.Context, conn net.PacketConn, server *net.UDPAddr, canChangeIP bool) string {
+	if _, err := c.binding(ctx, conn, server, 0); err != nil {
+		return Unknown
+	}
+	if canChangeIP {
+		if _, err := c.binding(ctx, conn, server, changeIP|changePort); err == nil {
+			return EndpointIndependent
+		}
+	}
+	_, err := c.binding(ctx, conn, server, changePort)
+	switch {
+	case ctx.Err() != nil:
+		return Unknown
+	case err != nil:
+		return AddressAndPortDependent
+	case canChangeIP:
+		return AddressDependent
+	}
+	// 单 IP 的服务器无法区分地址无关与地址相关过滤
+	return Unknown
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
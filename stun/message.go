@@ -0,0 +1,168 @@
+package stun
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+const (
+	magicCookie = 0x2112A442
+	headerSize  = 20
+
+	typeBindingRequest  = 0x0001
+	typeBindingResponse = 0x0101
+
+	attrMappedAddress    = 0x0001
+	attrChangeRequest    = 0x0003
+	attrXorMappedAddress = 0x0020
+	attrSoftware         = 0x8022
+	attrResponseOrigin   = 0x802B
+	attrOtherAddress     = 0x802C
+
+	changeIP   = 0x04
+	changePort = 0x02
+)
+
+var errInvalidMessage = errors.New("invalid stun message")
+
+type attribute struct {
+	typ   uint16
+	value []byte
+}
+
+type message struct {
+	typ   uint16
+	txID  [12]byte
+	attrs []attribute
+}
+
+func newBindingRequest(change uint32) *message {
+	m := &message{typ: typeBindingRequest}
+	rand.Read(m.txID[:])
+	if change != 0 {
+		value := make([]byte, 4)
+		binary.BigEndian.PutUint32(value, change)
+		m.add(attrChangeRequest, value)
+	}
+	return m
+}
+
+func (m *message) add(typ uint16, value []byte) {
+	m.attrs = append(m.attrs, attribute{typ: typ, value: value})
+}
+
+func (m *message) get(typ uint16) []byte {
+	for _, attr := range m.attrs {
+		if attr.typ == typ {
+			return attr.value
+		}
+	}
+	return nil
+}
+
+func (m *message) encode() []byte {
+	body := make([]byte, 0, 64)
+	for _, attr := range m.attrs {
+		var h [4]byte
+		binary.BigEndian.PutUint16(h[0:], attr.typ)
+		binary.BigEndian.PutUint16(h[2:], uint16(len(attr.value)))
+		body = append(body, h[:]...)
+		body = append(body, attr.value...)
+		// 属性按 4 字节对齐
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+
+	b := make([]byte, headerSize, headerSize+len(body))
+	binary.BigEndian.PutUint16(b[0:], m.typ)
+	binary.BigEndian.PutUint16(b[2:], uint16(len(body)))
+	binary.BigEndian.PutUint32(b[4:], magicCookie)
+	copy(b[8:], m.txID[:])
+	return append(b, body...)
+}
+
+func decode(b []byte) (*message, error) {
+	if len(b) < headerSize || binary.BigEndian.Uint32(b[4:]) != magicCookie {
+		return nil, errInvalidMessage
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if len(b) < headerSize+length {
+		return nil, errInvalidMessage
+	}
+
+	m := &message{typ: binary.BigEndian.Uint16(b[0:])}
+	copy(m.txID[:], b[8:20])
+
+	body := b[headerSize : headerSize+length]
+	for len(body) >= 4 {
+		typ := binary.BigEndian.Uint16(body[0:])
+		size := int(binary.BigEndian.Uint16(body[2:]))
+		if len(body) < 4+size {
+			return nil, errInvalidMessage
+		}
+		m.add(typ, body[4:4+size])
+		padded := 4 + (size+3)&^3
+		if padded > len(body) {
+			break
+		}
+		body = body[padded:]
+	}
+	return m, nil
+}
+
+// encodeAddress 编码 MAPPED-ADDRESS 类属性，xor 为 true 时按 XOR-MAPPED-ADDRESS 处理
+func encodeAddress(addr *net.UDPAddr, txID [12]byte, xor bool) []byte {
+	ip := addr.IP.To4()
+	family := byte(0x01)
+	if ip == nil {
+		ip = addr.IP.To16()
+		family = 0x02
+	}
+
+	value := make([]byte, 4+len(ip))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:], uint16(addr.Port))
+	copy(value[4:], ip)
+	if xor {
+		xorAddress(value, txID)
+	}
+	return value
+}
+
+func decodeAddress(value []byte, txID [12]byte, xor bool) (*net.UDPAddr, error) {
+	if len(value) < 8 {
+		return nil, errInvalidMessage
+	}
+	size := net.IPv4len
+	if value[1] == 0x02 {
+		size = net.IPv6len
+	}
+	if len(value) < 4+size {
+		return nil, errInvalidMessage
+	}
+
+	b := make([]byte, 4+size)
+	copy(b, value)
+	if xor {
+		xorAddress(b, txID)
+	}
+	return &net.UDPAddr{
+		IP:   net.IP(b[4:]),
+		Port: int(binary.BigEndian.Uint16(b[2:])),
+	}, nil
+}
+
+func xorAddress(value []byte, txID [12]byte) {
+	var key [16]byte
+	binary.BigEndian.PutUint32(key[:], magicCookie)
+	copy(key[4:], txID[:])
+
+	value[2] ^= key[0]
+	value[3] ^= key[1]
+	for i := 4; i < len(value); i++ {
+		value[i] ^= key[i-4]
+	}
+}
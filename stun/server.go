@@ -0,0 +1,129 @@
+package stun
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Server 是一个最小化的 RFC 5780 STUN 服务端，用作本地测试替身。
+// 它在 (IP, 端口) 的组合上监听，并根据 CHANGE-REQUEST 从对应的地址回复。
+// 只给出一个 IP 时无法改变来源 IP，请求改变 IP 的包会被忽略。
+type Server struct {
+	// conns[i][j] 为第 i 个 IP、第 j 个端口上的监听
+	conns [2][2]net.PacketConn
+	wg    sync.WaitGroup
+}
+
+// Listen 在 primaryIP 与可选的 alternateIP 上各监听两个端口，端口为 0 时随机选择
+func Listen(primaryIP, alternateIP string, primaryPort, alternatePort int) (*Server, error) {
+	s := &Server{}
+	ips := []string{primaryIP}
+	if alternateIP != "" {
+		ips = append(ips, alternateIP)
+	}
+	ports := [2]int{primaryPort, alternatePort}
+
+	for i, ip := range ips {
+		for j := range ports {
+			pc, err := net.ListenPacket("udp", net.JoinHostPort(ip, strconv.Itoa(ports[j])))
+			if err != nil {
+				s.Close()
+				return nil, err
+			}
+			// 所有 IP 使用相同的端口对
+			ports[j] = pc.LocalAddr().(*net.UDPAddr).Port
+			s.conns[i][j] = pc
+		}
+	}
+	return s, nil
+}
+
+// Addr 返回主地址
+func (s *Server) Addr() *net.UDPAddr {
+	return s.conns[0][0].LocalAddr().(*net.UDPAddr)
+}
+
+// Serve 开始处理请求，直到 Close 被调用
+func (s *Server) Serve() {
+	for i := range s.conns {
+		for j := range s.conns[i] {
+			if s.conns[i][j] == nil {
+				continue
+			}
+			s.wg.Add(1)
+			go s.serve(i, j)
+		}
+	}
+	s.wg.Wait()
+}
+
+func (s *Server) Close() error {
+	for i := range s.conns {
+		for j := range s.conns[i] {
+			if s.conns[i][j] != nil {
+				s.conns[i][j].Close()
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Server) serve(ipIdx, portIdx int) {
+	defer s.wg.Done()
+
+	pc := s.conns[ipIdx][portIdx]
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req, err := decode(buf[:n])
+		if err != nil || req.typ != typeBindingRequest {
+			continue
+		}
+		src, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		var change uint32
+		if value := req.get(attrChangeRequest); len(value) >= 4 {
+			change = binary.BigEndian.Uint32(value)
+		}
+		respIP, respPort := ipIdx, portIdx
+		if change&changeIP != 0 {
+			respIP ^= 1
+		}
+		if change&changePort != 0 {
+			respPort ^= 1
+		}
+		out := s.conns[respIP][respPort]
+		if out == nil {
+			continue
+		}
+
+		resp := &message{typ: typeBindingResponse, txID: req.txID}
+		resp.add(attrXorMappedAddress, encodeAddress(src, req.txID, true))
+		resp.add(attrMappedAddress, encodeAddress(src, req.txID, false))
+		resp.add(attrResponseOrigin, encodeAddress(out.LocalAddr().(*net.UDPAddr), req.txID, false))
+		if other := s.otherAddr(ipIdx, portIdx); other != nil {
+			resp.add(attrOtherAddress, encodeAddress(other, req.txID, false))
+		}
+		resp.add(attrSoftware, []byte("mihomo-speedtest"))
+		out.WriteTo(resp.encode(), src)
+	}
+}
+
+// otherAddr 返回 IP 与端口都不同的备用地址；单 IP 时只改变端口
+func (s *Server) otherAddr(ipIdx, portIdx int) *net.UDPAddr {
+	if pc := s.conns[ipIdx^1][portIdx^1]; pc != nil {
+		return pc.LocalAddr().(*net.UDPAddr)
+	}
+	if pc := s.conns[ipIdx][portIdx^1]; pc != nil {
+		return pc.LocalAddr().(*net.UDPAddr)
+	}
+	return nil
+}
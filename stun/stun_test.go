@@ -0,0 +1,236 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAddressRoundTrip(t *testing.T) {
+	m := newBindingRequest(changeIP | changePort)
+	addrs := []*net.UDPAddr{
+		{IP: net.ParseIP("203.0.113.7"), Port: 54321},
+		{IP: net.ParseIP("2001:db8::1"), Port: 3478},
+	}
+	for _, addr := range addrs {
+		for _, xor := range []bool{true, false} {
+			got, err := decodeAddress(encodeAddress(addr, m.txID, xor), m.txID, xor)
+			if err != nil {
+				t.Fatalf("decodeAddress(%v, xor=%v) error: %v", addr, xor, err)
+			}
+			if !sameAddr(got, addr) {
+				t.Errorf("decodeAddress(%v, xor=%v) = %v", addr, xor, got)
+			}
+		}
+	}
+
+	decoded, err := decode(m.encode())
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if decoded.txID != m.txID || len(decoded.get(attrChangeRequest)) != 4 {
+		t.Errorf("decode(encode(m)) = %+v; want %+v", decoded, m)
+	}
+}
+
+func listenTestServer(t *testing.T) *Server {
+	s, err := Listen("127.0.0.1", "127.0.0.2", 0, 0)
+	if err != nil {
+		t.Skipf("cannot listen on two loopback addresses: %v", err)
+	}
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestDiscoverNoNAT(t *testing.T) {
+	s := listenTestServer(t)
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	c := &Client{Conn: pc, RetransmitTimeout: 200 * time.Millisecond}
+	c.NewConn = func(context.Context) (net.PacketConn, error) { return net.ListenPacket("udp", "127.0.0.1:0") }
+	b, err := c.Discover(context.Background(), s.Addr())
+	if err != nil {
+		t.Fatalf("Discover error: %v", err)
+	}
+	if !sameAddr(b.MappedAddr, pc.LocalAddr().(*net.UDPAddr)) {
+		t.Errorf("MappedAddr = %v; want %v", b.MappedAddr, pc.LocalAddr())
+	}
+	if b.NATType() != "full-cone" {
+		t.Errorf("NATType() = %s; want full-cone (%+v)", b.NATType(), b)
+	}
+}
+
+// symmetricConn 模拟对称型 NAT：每个目的地址使用独立的本地端口，
+// 且只接受来自该目的地址的数据报
+type symmetricConn struct {
+	net.PacketConn // 仅用于满足接口，不直接收发
+
+	mu       sync.Mutex
+	conns    map[string]net.PacketConn
+	incoming chan []byte
+	deadline time.Time
+}
+
+func newSymmetricConn() *symmetricConn {
+	return &symmetricConn{conns: map[string]net.PacketConn{}, incoming: make(chan []byte, 16)}
+}
+
+func (c *symmetricConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	pc, ok := c.conns[addr.String()]
+	if !ok {
+		var err error
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			c.mu.Unlock()
+			return 0, err
+		}
+		c.conns[addr.String()] = pc
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				n, src, err := pc.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				if src.String() == addr.String() {
+					c.incoming <- append([]byte(nil), buf[:n]...)
+				}
+			}
+		}()
+	}
+	c.mu.Unlock()
+	return pc.WriteTo(b, addr)
+}
+
+func (c *symmetricConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case p := <-c.incoming:
+		return copy(b, p), nil, nil
+	case <-time.After(time.Until(c.deadline)):
+		return 0, nil, errNoResponse
+	}
+}
+
+func (c *symmetricConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *symmetricConn) Close() error {
+	for _, pc := range c.conns {
+		pc.Close()
+	}
+	return nil
+}
+
+func TestDiscoverSymmetricNAT(t *testing.T) {
+	s := listenTestServer(t)
+
+	pc := newSymmetricConn()
+	defer pc.Close()
+
+	c := &Client{Conn: pc, RetransmitTimeout: 100 * time.Millisecond}
+	c.NewConn = func(context.Context) (net.PacketConn, error) { return newSymmetricConn(), nil }
+	b, err := c.Discover(context.Background(), s.Addr())
+	if err != nil {
+		t.Fatalf("Discover error: %v", err)
+	}
+	if b.Mapping != AddressAndPortDependent || b.Filtering != AddressAndPortDependent {
+		t.Errorf("Discover() = %+v; want address-and-port-dependent mapping and filtering", b)
+	}
+	if b.NATType() != "symmetric" {
+		t.Errorf("NATType() = %s; want symmetric", b.NATType())
+	}
+}
+
+// restrictedConn 模拟限制型锥形 NAT：所有目的地址共用一个本地端口，
+// 但只接受来自曾经发送过数据的 IP 的数据报
+type restrictedConn struct {
+	net.PacketConn
+
+	mu   sync.Mutex
+	sent map[string]bool
+}
+
+func newRestrictedConn() (*restrictedConn, error) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return &restrictedConn{PacketConn: pc, sent: map[string]bool{}}, nil
+}
+
+func (c *restrictedConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	c.sent[addr.(*net.UDPAddr).IP.String()] = true
+	c.mu.Unlock()
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func (c *restrictedConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, src, err := c.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, src, err
+		}
+		c.mu.Lock()
+		allowed := c.sent[src.(*net.UDPAddr).IP.String()]
+		c.mu.Unlock()
+		if allowed {
+			return n, src, nil
+		}
+	}
+}
+
+func TestDiscoverRestrictedConeNAT(t *testing.T) {
+	s := listenTestServer(t)
+
+	pc, err := newRestrictedConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	c := &Client{Conn: pc, RetransmitTimeout: 100 * time.Millisecond}
+	c.NewConn = func(context.Context) (net.PacketConn, error) { return newRestrictedConn() }
+	b, err := c.Discover(context.Background(), s.Addr())
+	if err != nil {
+		t.Fatalf("Discover error: %v", err)
+	}
+	// 复用映射测试的端口时备用 IP 的响应可以通过，会被误判为 full-cone
+	if b.NATType() != "restricted-cone" {
+		t.Errorf("NATType() = %s; want restricted-cone (%+v)", b.NATType(), b)
+	}
+}
+
+func TestDiscoverCancelled(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	// 没有服务器监听的端口
+	dead, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	addr := dead.LocalAddr().(*net.UDPAddr)
+	dead.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	c := &Client{Conn: pc, RetransmitTimeout: time.Second}
+	start := time.Now()
+	if _, err := c.Discover(ctx, addr); err == nil {
+		t.Error("Discover succeeded without a server")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Discover ignored ctx, took %s", elapsed)
+	}
+}
@@ -0,0 +1,63 @@
+package tester
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+	C "github.com/metacubex/mihomo/constant"
+)
+
+// AdaptiveOptions 描述自适应下载大小：先下载小文件探测速度，再按目标时长决定正式下载的大小。
+// 正式下载不超过 Options.SizeMB，也不超过 Options.MaxTraffic 剩余的流量。
+type AdaptiveOptions struct {
+	ProbeSizeMB    int
+	TargetDuration time.Duration
+}
+
+// testProxyAdaptive 先探测再按目标时长下载
+func testProxyAdaptive(ctx context.Context, name string, proxy C.Proxy, streams []int64, opts Options) result.Result {
+	a := opts.Adaptive
+	probeSize := int64(a.ProbeSizeMB) * 1024 * 1024
+	probe := testProxyConcurrent(ctx, name, proxy, int(probeSize), opts.Timeout, streams, opts.LivenessObject)
+	probe.TestSize = probeSize
+	if probe.Bandwidth <= 0 || ctx.Err() != nil {
+		return probe
+	}
+
+	size := adaptiveSize(probe.Bandwidth, a.TargetDuration, probeSize, int64(opts.SizeMB)*1024*1024)
+	// 流量计数在连接层统计，已包括探测下载
+	if remaining, ok := remainingTraffic(opts); ok && size > remaining {
+		size = remaining
+	}
+	// 剩余流量不足以进行比探测更大的下载时以探测结果为准
+	if size <= probeSize {
+		return probe
+	}
+
+	res := testProxyConcurrent(ctx, name, proxy, int(size), opts.Timeout, streams, opts.LivenessObject)
+	res.TestSize = size
+	return res
+}
+
+// adaptiveSize 返回按探测速度下载 target 时长所需的大小，限制在 [probe, ceiling] 之间
+func adaptiveSize(bandwidth float64, target time.Duration, probe, ceiling int64) int64 {
+	size := int64(bandwidth * target.Seconds())
+	if size > ceiling {
+		size = ceiling
+	}
+	if size < probe {
+		size = probe
+	}
+	return size
+}
+
+// downloadedBytes 返回各并发连接已下载的字节数之和
+func downloadedBytes(streams []int64) int64 {
+	total := int64(0)
+	for i := range streams {
+		total += atomic.LoadInt64(&streams[i])
+	}
+	return total
+}
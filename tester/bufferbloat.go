@@ -0,0 +1,127 @@
+package tester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+	cutils "github.com/metacubex/mihomo/common/utils"
+	C "github.com/metacubex/mihomo/constant"
+)
+
+const (
+	idleLatencyProbes     = 3
+	loadedLatencyInterval = 250 * time.Millisecond
+)
+
+// withLoadedLatency 先测试空闲时的延迟，再在 download 运行期间持续测试延迟，
+// 将两者的中位数写入结果。负载下超时的探测按 timeout 计入。
+func withLoadedLatency(ctx context.Context, proxy C.Proxy, url string, timeout time.Duration, download func() result.Result) result.Result {
+	expectedStatus, _ := cutils.NewUnsignedRanges[uint16]("200")
+
+	// 所有探测复用同一个保持连接的 client，延迟不包括建立连接与 TLS 握手的时间
+	client := latencyClient(proxy)
+	defer client.CloseIdleConnections()
+	probeLatency(ctx, client, url, timeout, expectedStatus) // 预先建立连接，不计入
+
+	idle := make([]time.Duration, 0, idleLatencyProbes)
+	for i := 0; i < idleLatencyProbes; i++ {
+		if d, ok := probeLatency(ctx, client, url, timeout, expectedStatus); ok {
+			idle = append(idle, d)
+		}
+	}
+
+	loadCtx, stop := context.WithCancel(ctx)
+	var loaded []time.Duration
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(loadedLatencyInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-loadCtx.Done():
+				return
+			case <-ticker.C:
+			}
+			if d, ok := probeLatency(loadCtx, client, url, timeout, expectedStatus); ok {
+				loaded = append(loaded, d)
+			}
+		}
+	}()
+
+	res := download()
+	stop()
+	wg.Wait()
+
+	res.IdleLatency = median(idle)
+	res.LoadedLatency = median(loaded)
+	return res
+}
+
+// probeLatency 进行一次延迟测试。探测自身超时时返回 timeout，
+// 因 ctx 被取消（下载结束）或其他错误而失败的探测不计入。
+func probeLatency(ctx context.Context, client *http.Client, url string, timeout time.Duration, expectedStatus cutils.IntRanges[uint16]) (time.Duration, bool) {
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	d, err := urlLatency(probeCtx, client, url, expectedStatus)
+	switch {
+	case err == nil:
+		return d, true
+	case ctx.Err() == nil && errors.Is(probeCtx.Err(), context.DeadlineExceeded):
+		return timeout, true
+	default:
+		return 0, false
+	}
+}
+
+// latencyClient 返回通过代理发送延迟探测的 client，连接在探测之间保持
+func latencyClient(proxy C.Proxy) *http.Client {
+	return &http.Client{
+		Transport: getProxyTransport(proxy),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// urlLatency 发送 HEAD 请求，返回收到响应所用的时间
+func urlLatency(ctx context.Context, client *http.Client, url string, expectedStatus cutils.IntRanges[uint16]) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if expectedStatus != nil && !expectedStatus.Check(uint16(resp.StatusCode)) {
+		return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return time.Since(start), nil
+}
+
+func median(samples []time.Duration) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,58 @@
+package tester
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/geoip"
+	"github.com/0x10240/mihomo-speedtest/result"
+	C "github.com/metacubex/mihomo/constant"
+)
+
+// setProxyGeoIP 使用本地数据库补充入口（节点服务器）与出口 IP 的地理位置及 ASN 信息，不经过代理
+func setProxyGeoIP(ctx context.Context, proxy C.Proxy, res *result.Result, db *geoip.DB, timeout time.Duration) {
+	if ip := resolveEntryIP(ctx, proxy, timeout); ip != nil {
+		entry := db.Lookup(ip)
+		res.EntryIP = ip.String()
+		res.EntryCountry = entry.Country
+		res.EntryCity = entry.City
+		res.EntryASN = entry.ASN
+		res.EntryOrg = entry.Org
+	}
+
+	exit := db.Lookup(net.ParseIP(res.OutBoundIp))
+	// 在线查询的结果优先
+	if res.Country == "" {
+		res.Country = exit.Country
+	}
+	if res.City == "" {
+		res.City = exit.City
+	}
+	if res.Org == "" {
+		res.Org = exit.Org
+	}
+	res.ASN = exit.ASN
+	res.ExitType = geoip.Classify(exit.Org)
+}
+
+func resolveEntryIP(ctx context.Context, proxy C.Proxy, timeout time.Duration) net.IP {
+	host, _, err := net.SplitHostPort(proxyServer(proxy))
+	if err != nil {
+		host = proxyServer(proxy)
+	}
+	if host == "" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil || len(ips) == 0 {
+		return nil
+	}
+	return ips[0]
+}
@@ -0,0 +1,48 @@
+package tester
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+	"github.com/0x10240/mihomo-speedtest/stun"
+	C "github.com/metacubex/mihomo/constant"
+)
+
+// setProxyNATResult 通过代理的 UDP 转发对 STUN 服务器进行 RFC 5780 行为探测，并写入 res
+func setProxyNATResult(ctx context.Context, proxy C.Proxy, res *result.Result, stunServer string, timeout time.Duration) {
+	res.NATType = stun.Unknown
+
+	addr, err := net.ResolveUDPAddr("udp", stunServer)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	listen := func(ctx context.Context) (net.PacketConn, error) {
+		metadata := &C.Metadata{NetWork: C.UDP}
+		if err := metadata.SetRemoteAddr(addr); err != nil {
+			return nil, err
+		}
+		return proxy.ListenPacketContext(ctx, metadata)
+	}
+	pc, err := listen(ctx)
+	if err != nil {
+		return
+	}
+	defer pc.Close()
+
+	// 过滤测试使用新的 UDP 会话，即代理服务端上新的出口端口
+	client := &stun.Client{Conn: pc, NewConn: listen}
+	behavior, err := client.Discover(ctx, addr)
+	if err != nil {
+		return
+	}
+
+	res.NATType = behavior.NATType()
+	res.NATMapping = behavior.Mapping
+	res.NATFiltering = behavior.Filtering
+}
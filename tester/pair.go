@@ -0,0 +1,114 @@
+package tester
+
+import (
+	"context"
+	"sync"
+
+	"github.com/0x10240/mihomo-speedtest/config"
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+// TestSharedBandwidth 对同一分组内的节点两两同时测速：第一个节点依次与其余节点配对。
+// 若同时测速的带宽之和更接近单独测速时的较大值而不是两者之和，则认为两者共享带宽。
+// results 中需要已有单独测速的带宽，判定共享的节点会写入 SharedWith。
+func TestSharedBandwidth(ctx context.Context, results []result.Result, clusters []result.Cluster, proxies map[string]config.CProxy, opts Options) []result.PairResult {
+	index := make(map[string]int, len(results))
+	for i, res := range results {
+		index[res.Name] = i
+	}
+
+	pairs := make([]result.PairResult, 0)
+	tested := make(map[[2]string]bool)
+	opts = opts.withTraffic()
+	total := opts.Traffic
+
+	for _, c := range clusters {
+		a := c.Names[0]
+		for _, b := range c.Names[1:] {
+			if tested[pairKey(a, b)] {
+				continue
+			}
+			tested[pairKey(a, b)] = true
+
+			ra, rb := &results[index[a]], &results[index[b]]
+			if ra.Bandwidth <= 0 || rb.Bandwidth <= 0 {
+				continue
+			}
+			if ctx.Err() != nil || overBudget(opts, total) {
+				return pairs
+			}
+			sizes := pairSizes(ra, rb, opts)
+			if sizes[0] <= 0 || sizes[1] <= 0 {
+				return pairs
+			}
+
+			var together [2]result.Result
+			var wg sync.WaitGroup
+			for i, name := range []string{a, b} {
+				wg.Add(1)
+				go func(i int, name string) {
+					defer wg.Done()
+					together[i] = testProxyConcurrent(ctx, name, meter(proxies[name], total), int(sizes[i]), opts.Timeout, make([]int64, streamCount(opts.Concurrent)), opts.LivenessObject)
+				}(i, name)
+			}
+			wg.Wait()
+
+			pair := result.PairResult{
+				A:         a,
+				B:         b,
+				AloneA:    ra.Bandwidth,
+				AloneB:    rb.Bandwidth,
+				TogetherA: together[0].Bandwidth,
+				TogetherB: together[1].Bandwidth,
+			}
+			pair.SharedBandwidth = isSharedBandwidth(pair)
+			if pair.SharedBandwidth {
+				ra.SharedWith = append(ra.SharedWith, b)
+				rb.SharedWith = append(rb.SharedWith, a)
+			}
+			pairs = append(pairs, pair)
+		}
+	}
+	return pairs
+}
+
+// pairSizes 返回同时测速时两个节点的下载大小，与单独测速时相同（-adaptive 时为各自的自适应大小），
+// 有流量上限时两者之和不超过剩余流量
+func pairSizes(ra, rb *result.Result, opts Options) [2]int64 {
+	var sizes [2]int64
+	for i, res := range []*result.Result{ra, rb} {
+		sizes[i] = int64(opts.SizeMB) * 1024 * 1024
+		if res.TestSize > 0 {
+			sizes[i] = res.TestSize
+		}
+	}
+	if remaining, ok := remainingTraffic(opts); ok && sizes[0]+sizes[1] > remaining {
+		for i := range sizes {
+			if sizes[i] > remaining/2 {
+				sizes[i] = remaining / 2
+			}
+		}
+	}
+	return sizes
+}
+
+// pairKey 返回与顺序无关的节点对，(b, a) 与 (a, b) 只测试一次
+func pairKey(a, b string) [2]string {
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
+
+func isSharedBandwidth(p result.PairResult) bool {
+	if p.TogetherA <= 0 || p.TogetherB <= 0 {
+		return false
+	}
+	independent := p.AloneA + p.AloneB
+	shared := p.AloneA
+	if p.AloneB > shared {
+		shared = p.AloneB
+	}
+	// 取两种假设的中点作为判定阈值
+	return p.TogetherA+p.TogetherB < (independent+shared)/2
+}
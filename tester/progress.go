@@ -0,0 +1,38 @@
+package tester
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+// Progress 接收带宽测试的实时进度，用于显示
+type Progress interface {
+	// Begin 在测试开始前调用，total 为待测试的节点数
+	Begin(total int)
+	// Start 在开始测试节点时调用，streams 为各并发连接已下载的字节数（需原子读取），
+	// 调用 skip 可跳过该节点
+	Start(name string, streams []int64, skip context.CancelFunc)
+	// Done 在节点测试完成后调用，skipped 表示节点被跳过，不计入结果
+	Done(res result.Result, skipped bool)
+	// End 在全部测试结束或中断后调用
+	End()
+}
+
+// printProgress 每完成一个节点输出一行，用于非终端环境
+type printProgress struct{}
+
+func (printProgress) Begin(total int) {
+	fmt.Printf("%-42s\t%-12s\t%-12s\n", "Node", "Bandwidth", "Latency")
+}
+
+func (printProgress) Start(name string, streams []int64, skip context.CancelFunc) {}
+
+func (printProgress) Done(res result.Result, skipped bool) {
+	if !skipped {
+		res.Print()
+	}
+}
+
+func (printProgress) End() {}
@@ -0,0 +1,307 @@
+package tester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/config"
+	"github.com/0x10240/mihomo-speedtest/result"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	C "github.com/metacubex/mihomo/constant"
+)
+
+const (
+	SoakModeStream    = "stream"
+	SoakModeWebSocket = "ws"
+
+	soakCheckInterval = 250 * time.Millisecond
+	// SoakEchoInterval 为 ws 模式发送消息的间隔，StallThreshold 需要比它长
+	SoakEchoInterval = time.Second
+	// soakAbortFactor 为卡顿超过 StallThreshold 的多少倍后断开重连
+	soakAbortFactor = 3
+)
+
+// SoakOptions 描述长时间稳定性测试：通过每个节点保持一条长连接，记录卡顿、重置与重连
+type SoakOptions struct {
+	Duration time.Duration
+	// Mode 为 stream（持续慢速下载）或 ws（websocket 回显）
+	Mode string
+	// URL 为 stream 模式下持续输出数据的下载地址，或 ws 模式下的 websocket 回显地址
+	URL string
+	// StallThreshold 为判定卡顿的无数据时长
+	StallThreshold time.Duration
+}
+
+// TestProxiesSoak 同时对所有节点进行长时间稳定性测试。ctx 被取消时提前结束，
+// 已进行的时间仍计入结果。
+func TestProxiesSoak(ctx context.Context, names []string, proxies map[string]config.CProxy, opts Options) []result.Result {
+	results := make([]result.Result, 0, len(names))
+	mu := sync.Mutex{}
+	opts = opts.withTraffic()
+	total := opts.Traffic
+
+	fmt.Printf("Soak testing %d nodes for %s\n", len(names), opts.Soak.Duration)
+
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string, proxy config.CProxy) {
+			defer wg.Done()
+			traffic := int64(0)
+			res := testProxySoak(ctx, meter(proxy, &traffic, total), *opts.Soak)
+			res.Name = name
+			res.Server = proxyServer(proxy)
+			res.Traffic = atomic.LoadInt64(&traffic)
+
+			mu.Lock()
+			results = append(results, res)
+			fmt.Printf("%-42s\tuptime %.1f%%\t%d stalls\t%d resets\t%d reconnects\n", name, res.SoakUptime*100, res.SoakStalls, res.SoakResets, res.SoakReconnects)
+			mu.Unlock()
+		}(name, proxies[name])
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results
+}
+
+// soakMonitor 按 soakCheckInterval 检查数据是否在流动
+type soakMonitor struct {
+	mu       sync.Mutex
+	lastData time.Time
+	// started 在第一次连接成功或失败后为 true，之前的时间不计入统计
+	started   bool
+	connected bool
+	stalled   bool
+	aborted   bool
+	abort     context.CancelFunc
+	stalls    int
+	up, total int
+}
+
+func (m *soakMonitor) data() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastData = time.Now()
+	m.stalled = false
+}
+
+// session 记录新的连接，abort 用于在卡顿过久时断开它
+func (m *soakMonitor) session(abort context.CancelFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.abort = abort
+	m.aborted = false
+}
+
+// connect 在连接建立后调用
+func (m *soakMonitor) connect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started = true
+	m.connected = true
+	m.lastData = time.Now()
+	m.stalled = false
+}
+
+// end 在连接结束后调用，返回连接是否曾经建立，以及是否因卡顿过久被断开
+func (m *soakMonitor) end() (connected bool, aborted bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	connected, aborted = m.connected, m.aborted
+	m.started = true
+	m.connected = false
+	return connected, aborted
+}
+
+// check 统计有数据流动的时间，连接上长时间没有数据时记为卡顿，卡顿过久时断开连接
+func (m *soakMonitor) check(now time.Time, threshold time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.started {
+		return
+	}
+	m.total++
+	idle := now.Sub(m.lastData)
+	if idle < threshold {
+		m.up++
+		return
+	}
+	if !m.connected {
+		return
+	}
+	if !m.stalled {
+		m.stalled = true
+		m.stalls++
+	}
+	if idle >= soakAbortFactor*threshold && !m.aborted {
+		m.aborted = true
+		m.abort()
+	}
+}
+
+func testProxySoak(ctx context.Context, proxy C.Proxy, opts SoakOptions) result.Result {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, opts.Duration)
+	defer cancel()
+
+	m := &soakMonitor{}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(soakCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				m.check(now, opts.StallThreshold)
+			}
+		}
+	}()
+
+	res := result.Result{}
+	for sessions := 0; ctx.Err() == nil; sessions++ {
+		if sessions > 0 {
+			res.SoakReconnects++
+		}
+		sessionCtx, abort := context.WithCancel(ctx)
+		m.session(abort)
+
+		var err error
+		if opts.Mode == SoakModeWebSocket {
+			err = soakWebSocket(sessionCtx, proxy, opts.URL, m)
+		} else {
+			err = soakStream(sessionCtx, proxy, opts.URL, m)
+		}
+		connected, aborted := m.end()
+		abort()
+		if ctx.Err() != nil {
+			break
+		}
+		// 已建立的连接出错结束时计为重置，被卡顿检测断开的只计为卡顿
+		if err != nil && connected && !aborted {
+			res.SoakResets++
+		}
+
+		// 连接立即失败时不要空转
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+		}
+	}
+	wg.Wait()
+
+	res.SoakDuration = time.Since(start)
+	res.SoakStalls = m.stalls
+	if m.total > 0 {
+		res.SoakUptime = float64(m.up) / float64(m.total)
+	}
+	return res
+}
+
+// soakStream 持续读取慢速下载的数据，直到连接出错或 ctx 结束
+func soakStream(ctx context.Context, proxy C.Proxy, url string, m *soakMonitor) error {
+	client := &http.Client{Transport: getProxyTransport(proxy)}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	m.connect()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			m.data()
+		}
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// soakWebSocket 每秒发送一条消息并等待回显，直到连接出错或 ctx 结束
+func soakWebSocket(ctx context.Context, proxy C.Proxy, url string, m *soakMonitor) error {
+	dialer := ws.Dialer{
+		NetDial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialProxy(ctx, proxy, addr)
+		},
+	}
+	conn, br, _, err := dialer.Dial(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// ctx 结束时关闭连接以中断读写
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	var r io.Reader = conn
+	if br != nil {
+		r = io.MultiReader(br, conn)
+	}
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{r, conn}
+
+	m.connect()
+	errCh := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(SoakEchoInterval)
+		defer ticker.Stop()
+		for seq := 0; ; seq++ {
+			if err := wsutil.WriteClientText(conn, []byte(fmt.Sprintf("mihomo-speedtest %d", seq))); err != nil {
+				errCh <- err
+				return
+			}
+			select {
+			case <-ctx.Done():
+				errCh <- ctx.Err()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	for {
+		if _, err := wsutil.ReadServerText(rw); err != nil {
+			conn.Close()
+			<-errCh
+			return err
+		}
+		m.data()
+	}
+}
@@ -0,0 +1,98 @@
+package tester
+
+import (
+	"time"
+)
+
+// sampleInterval 为记录下载进度的最小间隔
+const sampleInterval = 20 * time.Millisecond
+
+// byteSample 为某一时刻单个连接已下载的字节数
+type byteSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// streamStats 记录单个下载连接的开始、收到首字节与结束的时间
+type streamStats struct {
+	start     time.Time
+	firstByte time.Time
+	end       time.Time
+	bytes     int64
+	samples   []byteSample
+}
+
+func (s streamStats) ok() bool {
+	return s.bytes > 0
+}
+
+func (s streamStats) ttfb() time.Duration {
+	return s.firstByte.Sub(s.start)
+}
+
+// bytesAt 按记录的进度线性插值，返回 t 时刻已下载的字节数
+func (s streamStats) bytesAt(t time.Time) float64 {
+	if len(s.samples) == 0 || !t.After(s.samples[0].at) {
+		return 0
+	}
+	for i := 1; i < len(s.samples); i++ {
+		prev, next := s.samples[i-1], s.samples[i]
+		if t.After(next.at) {
+			continue
+		}
+		span := next.at.Sub(prev.at)
+		if span <= 0 {
+			return float64(next.bytes)
+		}
+		ratio := float64(t.Sub(prev.at)) / float64(span)
+		return float64(prev.bytes) + ratio*float64(next.bytes-prev.bytes)
+	}
+	return float64(s.bytes)
+}
+
+// minOverlap 为计算带宽时所有连接同时传输的最短时间，过短时改用整体传输时间
+const minOverlap = 200 * time.Millisecond
+
+// goodput 计算成功的连接在同时传输期间（最晚收到首字节到最早结束）的总下载速度。
+// 各连接几乎没有重叠时，改为以最早收到首字节到最晚结束的时间计算。
+func goodput(stats []streamStats) float64 {
+	var from, to, first, last time.Time
+	total := int64(0)
+	for _, s := range stats {
+		if !s.ok() {
+			continue
+		}
+		total += s.bytes
+		if from.IsZero() || s.firstByte.After(from) {
+			from = s.firstByte
+		}
+		if to.IsZero() || s.end.Before(to) {
+			to = s.end
+		}
+		if first.IsZero() || s.firstByte.Before(first) {
+			first = s.firstByte
+		}
+		if last.IsZero() || s.end.After(last) {
+			last = s.end
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+
+	if window := to.Sub(from); window >= minOverlap {
+		transferred := 0.0
+		for _, s := range stats {
+			if s.ok() {
+				transferred += s.bytesAt(to) - s.bytesAt(from)
+			}
+		}
+		return transferred / window.Seconds()
+	}
+
+	window := last.Sub(first)
+	if window <= 0 {
+		return 0
+	}
+	return float64(total) / window.Seconds()
+}
@@ -0,0 +1,49 @@
+package tester
+
+import (
+	"context"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+)
+
+// sweepMinGain 为增加并发连接数后带宽至少需要提升的比例，否则停止增加
+const sweepMinGain = 0.1
+
+// testProxySweep 以 1、2、4… 个并发连接依次调用 download，直到带宽提升不足 sweepMinGain
+// 或达到 maxStreams。返回带宽最高的一次结果，并记录单连接带宽与对应的最佳连接数。
+func testProxySweep(ctx context.Context, maxStreams int, download func(streams int) result.Result) result.Result {
+	var best result.Result
+	single := 0.0
+
+	for n := 1; ; {
+		res := download(n)
+		if ctx.Err() != nil {
+			return res
+		}
+		if n == 1 {
+			single = res.Bandwidth
+			// 单连接都无法下载时不再继续
+			if res.Bandwidth <= 0 {
+				best = res
+				break
+			}
+		}
+
+		improved := res.Bandwidth >= best.Bandwidth*(1+sweepMinGain)
+		if res.Bandwidth > best.Bandwidth {
+			best = res
+			best.BestStreams = n
+		}
+		if !improved || n >= maxStreams {
+			break
+		}
+
+		n *= 2
+		if n > maxStreams {
+			n = maxStreams
+		}
+	}
+
+	best.SingleStreamBandwidth = single
+	return best
+}
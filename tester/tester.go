@@ -3,7 +3,6 @@ package tester
 import (
 	"context"
 	"fmt"
-	"github.com/go-resty/resty/v2"
 	"io"
 	"net"
 	"net/http"
@@ -13,15 +12,69 @@ import (
 	"time"
 
 	"github.com/0x10240/mihomo-speedtest/config"
+	"github.com/0x10240/mihomo-speedtest/geoip"
+	"github.com/0x10240/mihomo-speedtest/outbound"
 	"github.com/0x10240/mihomo-speedtest/result"
 	cutils "github.com/metacubex/mihomo/common/utils"
 	C "github.com/metacubex/mihomo/constant"
 )
 
-func TestProxiesDelay(proxies map[string]config.CProxy, delayTestUrl string, timeout time.Duration) []result.Result {
+// Options 汇总一次测试运行所需的参数
+type Options struct {
+	SizeMB         int
+	Timeout        time.Duration
+	Concurrent     int
+	LivenessObject string
+	DelayTestUrl   string
+	// DelayCount 为每个节点的延迟测试次数，多次测试时计算抖动
+	DelayCount int
+
+	// UDP 为 nil 时不进行 UDP 测试
+	UDP *UDPOptions
+	// STUNServer 不为空时通过每个节点探测 NAT 类型
+	STUNServer string
+	// Resolvers 为按顺序尝试的出口 IP 查询方式，为空时不查询
+	Resolvers []outbound.Resolver
+	// GeoIP 不为 nil 时使用本地数据库补充入口与出口信息
+	GeoIP *geoip.DB
+
+	// OnResult 在每个节点测试完成后调用，用于保存进度
+	OnResult func(result.Result)
+	// Progress 为 nil 时每完成一个节点输出一行
+	Progress Progress
+	// Adaptive 不为 nil 时按探测速度决定每个节点的下载大小
+	Adaptive *AdaptiveOptions
+	// Soak 不为 nil 时进行长时间稳定性测试
+	Soak *SoakOptions
+	// LoadedLatency 为 true 时在下载期间持续测试延迟，与空闲时的延迟比较
+	LoadedLatency bool
+	// SweepMaxStreams 大于 0 时以 1、2、4… 个并发连接依次测速，寻找每个节点的最佳连接数，
+	// 此时忽略 Concurrent
+	SweepMaxStreams int
+
+	// Traffic 累计整次测试经过代理的流量（字节），需原子读取，为 nil 时不对外提供
+	Traffic *int64
+	// MaxTraffic 为整次测试的流量上限（字节），达到后不再开始新的测试，其余节点标记为跳过。0 表示不限制。
+	MaxTraffic int64
+}
+
+// withTraffic 在 Traffic 为 nil 时创建整次测试的流量计数，各入口在开始时调用一次，
+// 保证流量上限的各处检查使用同一个计数
+func (opts Options) withTraffic() Options {
+	if opts.Traffic == nil {
+		opts.Traffic = new(int64)
+	}
+	return opts
+}
+
+// TestProxiesDelay 并发测试节点延迟。ctx 被取消后不再开始新的测试，
+// 正在进行的测试被中断且不计入结果。
+func TestProxiesDelay(ctx context.Context, proxies map[string]config.CProxy, opts Options) []result.Result {
 	results := make([]result.Result, 0, len(proxies))
 	mu := sync.Mutex{} // 用于保护 results 切片的并发写操作
 	expectedStatus, _ := cutils.NewUnsignedRanges[uint16]("200")
+	opts = opts.withTraffic()
+	total := opts.Traffic
 
 	var wg sync.WaitGroup
 	semaphore := make(chan struct{}, 16) // 并发限制为16
@@ -31,25 +84,37 @@ func TestProxiesDelay(proxies map[string]config.CProxy, delayTestUrl string, tim
 		// 启动一个 goroutine
 		go func(name string, proxy config.CProxy) {
 			defer wg.Done()
-			semaphore <- struct{}{} // 获取一个令牌，控制并发数
+			semaphore <- struct{}{}        // 获取一个令牌，控制并发数
+			defer func() { <-semaphore }() // 释放令牌
 
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			defer cancel()
-
-			delay, err := proxy.URLTest(ctx, delayTestUrl, expectedStatus)
-			if err != nil {
-				delay = 9999
+			if ctx.Err() != nil {
+				return
 			}
-			res := result.Result{Name: name, Delay: delay}
-			if delay != 9999 {
-				setProxyOutboundIP(proxy, &res, timeout)
+
+			var res result.Result
+			if overBudget(opts, total) {
+				res = result.Result{Name: name, Server: proxyServer(proxy), Delay: 9999, Skipped: true}
+			} else {
+				traffic := int64(0)
+				proxy := meter(proxy, &traffic, total)
+				delay, jitter, successRate := testProxyDelay(ctx, proxy, opts.DelayTestUrl, opts.DelayCount, opts.Timeout, expectedStatus)
+				res = result.Result{Name: name, Delay: delay, Jitter: jitter, SuccessRate: successRate, Server: proxyServer(proxy)}
+				if delay != 9999 {
+					setProxyExtraResults(ctx, proxy, &res, opts)
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				res.Traffic = atomic.LoadInt64(&traffic)
 			}
+
 			// 使用互斥锁保护 results 的写入
 			mu.Lock()
 			results = append(results, res)
+			if opts.OnResult != nil && !res.Skipped {
+				opts.OnResult(res)
+			}
 			mu.Unlock()
-
-			<-semaphore // 释放令牌
 		}(name, proxy)
 	}
 
@@ -57,122 +122,277 @@ func TestProxiesDelay(proxies map[string]config.CProxy, delayTestUrl string, tim
 	return results
 }
 
-func TestProxies(names []string, proxies map[string]config.CProxy, sizeMB int, timeout time.Duration, concurrent int, livenessObject string) []result.Result {
+// testProxyDelay 进行 count 次延迟测试，返回成功测试的平均延迟、相邻两次的平均波动与成功率。
+// 全部失败时延迟为 9999。
+func testProxyDelay(ctx context.Context, proxy C.Proxy, url string, count int, timeout time.Duration, expectedStatus cutils.IntRanges[uint16]) (delay uint16, jitter uint16, successRate float64) {
+	if count <= 0 {
+		count = 1
+	}
+
+	samples := make([]uint16, 0, count)
+	for i := 0; i < count; i++ {
+		testCtx, cancel := context.WithTimeout(ctx, timeout)
+		d, err := proxy.URLTest(testCtx, url, expectedStatus)
+		cancel()
+		if err == nil {
+			samples = append(samples, d)
+		}
+	}
+	if len(samples) == 0 {
+		return 9999, 0, 0
+	}
+
+	total, variation := 0, 0
+	for i, d := range samples {
+		total += int(d)
+		if i > 0 {
+			diff := int(d) - int(samples[i-1])
+			if diff < 0 {
+				diff = -diff
+			}
+			variation += diff
+		}
+	}
+	delay = uint16(total / len(samples))
+	if len(samples) > 1 {
+		jitter = uint16(variation / (len(samples) - 1))
+	}
+	return delay, jitter, float64(len(samples)) / float64(count)
+}
+
+// TestProxies 依次测试节点带宽。ctx 被取消后返回已完成的结果，正在测试或被跳过的节点不计入结果。
+func TestProxies(ctx context.Context, names []string, proxies map[string]config.CProxy, opts Options) []result.Result {
 	results := make([]result.Result, 0, len(names))
-	fmt.Printf("%-42s\t%-12s\t%-12s\n", "Node", "Bandwidth", "Latency")
+	progress := opts.Progress
+	if progress == nil {
+		progress = printProgress{}
+	}
+	progress.Begin(len(names))
+	defer progress.End()
+	opts = opts.withTraffic()
+	total := opts.Traffic
 
-	for _, name := range names {
+	for i, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		if overBudget(opts, total) {
+			for _, name := range names[i:] {
+				res := result.Result{Name: name, Server: proxyServer(proxies[name]), Skipped: true}
+				progress.Done(res, true)
+				results = append(results, res)
+			}
+			break
+		}
 		proxy := proxies[name]
 		switch proxy.Type() {
 		case C.Shadowsocks, C.ShadowsocksR, C.Snell, C.Socks5, C.Http, C.Vmess, C.Vless, C.Trojan, C.Hysteria, C.Hysteria2, C.WireGuard, C.Tuic:
-			downloadSize := sizeMB * 1024 * 1024
-			res := testProxyConcurrent(name, proxy, downloadSize, timeout, concurrent, livenessObject)
-			res.Print()
+			traffic := int64(0)
+			proxy := meter(proxy, &traffic, total)
+			nodeCtx, skip := context.WithCancel(ctx)
+			progress.Start(name, nil, skip)
+
+			// download 使用 n 个并发连接下载一次
+			download := func(n int) result.Result {
+				streams := make([]int64, n)
+				progress.Start(name, streams, skip)
+
+				if opts.Adaptive != nil {
+					return testProxyAdaptive(nodeCtx, name, proxy, streams, opts)
+				}
+				downloadSize := opts.SizeMB * 1024 * 1024
+				res := testProxyConcurrent(nodeCtx, name, proxy, downloadSize, opts.Timeout, streams, opts.LivenessObject)
+				res.TestSize = int64(downloadSize)
+				return res
+			}
+			measure := func() result.Result {
+				if opts.SweepMaxStreams > 0 {
+					return testProxySweep(nodeCtx, opts.SweepMaxStreams, download)
+				}
+				return download(streamCount(opts.Concurrent))
+			}
+
+			var res result.Result
+			if opts.LoadedLatency {
+				res = withLoadedLatency(nodeCtx, proxy, opts.DelayTestUrl, opts.Timeout, measure)
+			} else {
+				res = measure()
+			}
+			res.Server = proxyServer(proxy)
+			setProxyExtraResults(nodeCtx, proxy, &res, opts)
+			res.Traffic = atomic.LoadInt64(&traffic)
+			skipped := nodeCtx.Err() != nil
+			skip()
+			if ctx.Err() != nil {
+				continue
+			}
+			progress.Done(res, skipped)
+			if skipped {
+				continue
+			}
 			results = append(results, res)
+			if opts.OnResult != nil {
+				opts.OnResult(res)
+			}
 		default:
+			progress.Done(result.Result{Name: name}, true)
 			continue // Skip unsupported proxy types
 		}
 	}
 	return results
 }
 
+func streamCount(concurrent int) int {
+	if concurrent <= 0 {
+		return 1
+	}
+	return concurrent
+}
+
+// setProxyExtraResults 对可用的节点进行出口 IP、UDP 等附加测试
+func setProxyExtraResults(ctx context.Context, proxy C.Proxy, res *result.Result, opts Options) {
+	setProxyOutboundIP(ctx, proxy, res, opts.Resolvers, opts.Timeout)
+	if opts.GeoIP != nil {
+		setProxyGeoIP(ctx, proxy, res, opts.GeoIP, opts.Timeout)
+	}
+	if opts.UDP != nil {
+		setProxyUDPResult(ctx, proxy, res, *opts.UDP, opts.Timeout)
+	}
+	if opts.STUNServer != "" {
+		setProxyNATResult(ctx, proxy, res, opts.STUNServer, opts.Timeout)
+	}
+}
+
+// proxyServer 返回节点的入口服务器地址（host:port），同一服务器的不同端口可能是不同的节点
+func proxyServer(proxy C.Proxy) string {
+	return proxy.Addr()
+}
+
 func getProxyTransport(proxy C.Proxy) *http.Transport {
 	return &http.Transport{
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			host, portStr, err := net.SplitHostPort(addr)
-			if err != nil {
-				return nil, err
-			}
-			port, err := strconv.ParseUint(portStr, 10, 16)
-			if err != nil {
-				return nil, err
-			}
-			return proxy.DialContext(ctx, &C.Metadata{
-				Host:    host,
-				DstPort: uint16(port),
-			})
+			return dialProxy(ctx, proxy, addr)
 		},
 	}
 }
 
-func setProxyOutboundIP(proxy C.Proxy, res *result.Result, timeout time.Duration) {
-	client := resty.New()
-	transport := getProxyTransport(proxy)
-	client.SetTimeout(timeout)
-	client.SetTransport(transport)
-	resp, err := client.R().Get("https://speed.cloudflare.com/__down?bytes=1")
+// dialProxy 通过代理建立到 host:port 的 TCP 连接
+func dialProxy(ctx context.Context, proxy C.Proxy, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
 	if err != nil {
-		return
+		return nil, err
 	}
-	res.OutBoundIp = resp.Header().Get("Cf-Meta-Ip")
-	res.Country = resp.Header().Get("Cf-Meta-Country")
-	//fmt.Printf("%v outbount ip: %v\n", res.Name, res.OutBoundIp)
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.DialContext(ctx, &C.Metadata{
+		Host:    host,
+		DstPort: uint16(port),
+	})
 }
 
-func testProxyConcurrent(name string, proxy C.Proxy, downloadSize int, timeout time.Duration, concurrentCount int, livenessObject string) result.Result {
-	if concurrentCount <= 0 {
-		concurrentCount = 1
+func setProxyOutboundIP(ctx context.Context, proxy C.Proxy, res *result.Result, resolvers []outbound.Resolver, timeout time.Duration) {
+	if len(resolvers) == 0 {
+		return
 	}
 
+	client := &http.Client{
+		Transport: getProxyTransport(proxy),
+	}
+	info, err := outbound.Resolve(ctx, client, resolvers, timeout)
+	if err != nil {
+		return
+	}
+	res.OutBoundIp = info.IP
+	res.Country = info.Country
+	res.City = info.City
+	res.Org = info.Org
+}
+
+// testProxyConcurrent 使用 len(streams) 个并发连接下载，streams 记录各连接已下载的字节数。
+// 带宽按各连接同时传输的时间窗口计算，不包括建立连接的时间；TTFB 只统计成功的连接。
+// 全部连接失败时带宽与 TTFB 为 -1。
+func testProxyConcurrent(ctx context.Context, name string, proxy C.Proxy, downloadSize int, timeout time.Duration, streams []int64, livenessObject string) result.Result {
+	concurrentCount := len(streams)
 	chunkSize := downloadSize / concurrentCount
-	totalTTFB := int64(0)
-	downloaded := int64(0)
+	stats := make([]streamStats, concurrentCount)
 
 	var wg sync.WaitGroup
-	start := time.Now()
-
 	for i := 0; i < concurrentCount; i++ {
 		wg.Add(1)
-		go func() {
+		go func(i int) {
 			defer wg.Done()
-			res, bytes := testProxy(name, proxy, chunkSize, timeout, livenessObject)
-			if bytes != 0 {
-				atomic.AddInt64(&downloaded, bytes)
-				atomic.AddInt64(&totalTTFB, int64(res.TTFB))
-			}
-		}()
+			stats[i] = testProxy(ctx, proxy, chunkSize, timeout, livenessObject, &streams[i])
+		}(i)
 	}
 	wg.Wait()
 
-	downloadTime := time.Since(start)
-	avgTTFB := time.Duration(totalTTFB / int64(concurrentCount))
-	bandwidth := float64(downloaded) / downloadTime.Seconds()
-
-	res := result.Result{
-		Name:      name,
-		Bandwidth: bandwidth,
-		TTFB:      avgTTFB,
+	succeeded := 0
+	totalTTFB := time.Duration(0)
+	for _, s := range stats {
+		if s.ok() {
+			succeeded++
+			totalTTFB += s.ttfb()
+		}
+	}
+	if succeeded == 0 {
+		return result.Result{Name: name, Bandwidth: -1, TTFB: -1, FailedStreams: concurrentCount}
 	}
 
-	setProxyOutboundIP(proxy, &res, timeout)
-	return res
+	return result.Result{
+		Name:          name,
+		Bandwidth:     goodput(stats),
+		TTFB:          totalTTFB / time.Duration(succeeded),
+		SuccessRate:   float64(succeeded) / float64(concurrentCount),
+		FailedStreams: concurrentCount - succeeded,
+	}
 }
 
-func testProxy(name string, proxy C.Proxy, downloadSize int, timeout time.Duration, livenessObject string) (result.Result, int64) {
+// testProxy 使用单个连接下载，counter 实时记录已下载的字节数
+func testProxy(ctx context.Context, proxy C.Proxy, downloadSize int, timeout time.Duration, livenessObject string, counter *int64) streamStats {
 	client := &http.Client{
 		Timeout:   timeout,
 		Transport: getProxyTransport(proxy),
 	}
 
-	start := time.Now()
-	resp, err := client.Get(fmt.Sprintf(livenessObject, downloadSize))
+	stats := streamStats{start: time.Now()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(livenessObject, downloadSize), nil)
+	if err != nil {
+		return stats
+	}
+	resp, err := client.Do(req)
 	if err != nil {
-		return result.Result{Name: name, Bandwidth: -1, TTFB: -1}, 0
+		return stats
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode >= 300 {
-		return result.Result{Name: name, Bandwidth: -1, TTFB: -1}, 0
+		return stats
 	}
 
-	ttfb := time.Since(start)
-	written, _ := io.Copy(io.Discard, resp.Body)
-	if written == 0 {
-		return result.Result{Name: name, Bandwidth: -1, TTFB: -1}, 0
-	}
+	stats.firstByte = time.Now()
+	w := &countingWriter{counter: counter, samples: []byteSample{{at: stats.firstByte}}}
+	// 超时中断时保留已下载的部分
+	io.Copy(w, resp.Body)
+	stats.end = time.Now()
+	stats.bytes = w.written
+	stats.samples = append(w.samples, byteSample{at: stats.end, bytes: w.written})
+	return stats
+}
 
-	downloadTime := time.Since(start) - ttfb
-	bandwidth := float64(written) / downloadTime.Seconds()
+// countingWriter 丢弃写入的数据，原子累加字节数并按 sampleInterval 记录下载进度
+type countingWriter struct {
+	counter *int64
+	written int64
+	samples []byteSample
+}
 
-	return result.Result{Name: name, Bandwidth: bandwidth, TTFB: ttfb}, written
+func (w *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.counter, int64(len(p)))
+	w.written += int64(len(p))
+	if now := time.Now(); now.Sub(w.samples[len(w.samples)-1].at) >= sampleInterval {
+		w.samples = append(w.samples, byteSample{at: now, bytes: w.written})
+	}
+	return len(p), nil
 }
@@ -0,0 +1,412 @@
+package tester
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/adapter/outbound"
+	"github.com/miekg/dns"
+)
+
+// linearStream 返回 start 后 setup 收到首字节、以 rate B/s 匀速下载 duration 的连接
+func linearStream(start time.Time, setup time.Duration, rate float64, duration time.Duration) streamStats {
+	s := streamStats{start: start, firstByte: start.Add(setup)}
+	s.end = s.firstByte.Add(duration)
+	for t := time.Duration(0); t <= duration; t += sampleInterval {
+		s.samples = append(s.samples, byteSample{at: s.firstByte.Add(t), bytes: int64(rate * t.Seconds())})
+	}
+	s.bytes = int64(rate * duration.Seconds())
+	s.samples = append(s.samples, byteSample{at: s.end, bytes: s.bytes})
+	return s
+}
+
+func TestGoodput(t *testing.T) {
+	start := time.Now()
+	stats := []streamStats{
+		// 建立连接耗时不同，不应计入带宽
+		linearStream(start, 100*time.Millisecond, 1000, 2*time.Second),
+		linearStream(start, 900*time.Millisecond, 1000, 2*time.Second),
+		{start: start}, // 失败的连接
+	}
+	if got := goodput(stats); math.Abs(got-2000) > 20 {
+		t.Errorf("goodput() = %.1f, want 2000", got)
+	}
+
+	// 没有重叠时按整体传输时间计算
+	stats = []streamStats{
+		linearStream(start, 0, 1000, time.Second),
+		linearStream(start, 2*time.Second, 1000, time.Second),
+	}
+	if got := goodput(stats); math.Abs(got-2000.0/3) > 1 {
+		t.Errorf("goodput() without overlap = %.1f, want %.1f", got, 2000.0/3)
+	}
+}
+
+func TestBuildUDPProbe(t *testing.T) {
+	payload, match, err := buildUDPProbe(UDPOptions{Mode: UDPModeDNS, Domain: "example.com"}, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := new(dns.Msg)
+	if err := query.Unpack(payload); err != nil || query.Question[0].Name != "example.com." {
+		t.Fatalf("dns probe: %v %v", query, err)
+	}
+	reply := func(id uint16, response bool) []byte {
+		msg := query.Copy()
+		msg.Id, msg.Response = id, response
+		b, _ := msg.Pack()
+		return b
+	}
+	if !match(reply(query.Id, true)) {
+		t.Error("dns reply not matched")
+	}
+	// 迟到的旧响应与原样返回的查询都不算
+	if match(reply(query.Id-1, true)) || match(reply(query.Id, false)) || match([]byte("garbage")) {
+		t.Error("dns probe matched a wrong reply")
+	}
+
+	payload, match, err = buildUDPProbe(UDPOptions{Mode: UDPModeEcho}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, _ := buildUDPProbe(UDPOptions{Mode: UDPModeEcho}, 2)
+	if !match(append([]byte(nil), payload...)) || match(other) {
+		t.Error("echo probe matching")
+	}
+
+	if _, _, err := buildUDPProbe(UDPOptions{Mode: "quic"}, 0); err == nil {
+		t.Error("unsupported mode accepted")
+	}
+}
+
+func TestUDPMetadata(t *testing.T) {
+	m, err := udpMetadata("dns.google:53")
+	if err != nil || m.Host != "dns.google" || m.DstPort != 53 || m.Resolved() {
+		t.Errorf("domain target: %+v %v", m, err)
+	}
+	m, err = udpMetadata("[2001:4860:4860::8888]:53")
+	if err != nil || m.Host != "" || !m.Resolved() {
+		t.Errorf("ip target: %+v %v", m, err)
+	}
+	if _, err := udpMetadata("8.8.8.8"); err == nil {
+		t.Error("target without port accepted")
+	}
+}
+
+func TestMeteredProxy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	var node, total int64
+	proxy := meter(adapter.NewProxy(outbound.NewDirect()), &node, &total)
+	if _, err := proxy.URLTest(context.Background(), server.URL, nil); err != nil {
+		t.Fatal(err)
+	}
+	// URLTest 的请求与响应都经过统计流量的连接
+	if node == 0 || node != total {
+		t.Errorf("URLTest traffic = %d/%d", node, total)
+	}
+
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 64)
+		n, addr, err := echo.ReadFrom(buf)
+		if err == nil {
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+	metadata, err := udpMetadata(echo.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := proxy.ListenPacketContext(context.Background(), metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	node = 0
+	if _, err := pc.WriteTo([]byte("ping"), echo.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	pc.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := pc.ReadFrom(make([]byte, 64)); err != nil {
+		t.Fatal(err)
+	}
+	if node != 8 {
+		t.Errorf("UDP traffic = %d, want 8", node)
+	}
+}
+
+func TestProxyUDPDeadline(t *testing.T) {
+	// 只回复第一个探测包的 echo 服务
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 64)
+		n, addr, err := echo.ReadFrom(buf)
+		if err == nil {
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	opts := UDPOptions{Target: echo.LocalAddr().String(), Mode: UDPModeEcho, Count: 4}
+	var res result.Result
+	start := time.Now()
+	setProxyUDPResult(ctx, adapter.NewProxy(outbound.NewDirect()), &res, opts, 2*time.Second)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("UDP test took %v; want it to stop at the context deadline", elapsed)
+	}
+	// 截止时间到达时按已发出的两个包计算，不标记为 broken
+	if res.UDPStatus != result.UDPStatusOK || res.UDPLoss != 0.5 {
+		t.Errorf("UDP result = %s, loss %v; want ok, 0.5", res.UDPStatus, res.UDPLoss)
+	}
+
+	// 等待时间不设下限，整体不超过 timeout
+	opts.Count = 10
+	start = time.Now()
+	if _, err := testProxyUDP(context.Background(), adapter.NewProxy(outbound.NewDirect()), opts, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Errorf("UDP test of 10 probes took %v; want at most the 500ms timeout", elapsed)
+	}
+}
+
+func TestOverBudget(t *testing.T) {
+	used := int64(100)
+	tests := []struct {
+		max       int64
+		over      bool
+		remaining int64
+		limited   bool
+	}{
+		{0, false, 0, false}, // 不限制
+		{50, true, -50, true},
+		{100, true, 0, true},
+		{300, false, 200, true},
+	}
+	for _, tt := range tests {
+		opts := Options{MaxTraffic: tt.max, Traffic: &used}
+		if got := overBudget(opts, &used); got != tt.over {
+			t.Errorf("overBudget(max %d) = %v, want %v", tt.max, got, tt.over)
+		}
+		if remaining, ok := remainingTraffic(opts); remaining != tt.remaining || ok != tt.limited {
+			t.Errorf("remainingTraffic(max %d) = %d %v, want %d %v", tt.max, remaining, ok, tt.remaining, tt.limited)
+		}
+	}
+
+	// 未指定 Traffic 时各处检查使用入口创建的同一个计数
+	opts := Options{MaxTraffic: 100}.withTraffic()
+	atomic.AddInt64(opts.Traffic, 60)
+	if remaining, _ := remainingTraffic(opts); remaining != 40 {
+		t.Errorf("remainingTraffic after using 60 of 100 = %d, want 40", remaining)
+	}
+}
+
+func TestAdaptiveSize(t *testing.T) {
+	const mb = 1024 * 1024
+	tests := []struct {
+		name      string
+		bandwidth float64
+		want      int64
+	}{
+		{"target duration", 10 * mb, 30 * mb},
+		{"capped by -size", 100 * mb, 100 * mb},
+		{"at least the probe", 0.1 * mb, 1 * mb},
+	}
+	for _, tt := range tests {
+		if got := adaptiveSize(tt.bandwidth, 3*time.Second, 1*mb, 100*mb); got != tt.want {
+			t.Errorf("%s: adaptiveSize() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMedian(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		samples []time.Duration
+		want    time.Duration
+	}{
+		{nil, 0},
+		{[]time.Duration{30 * ms}, 30 * ms},
+		{[]time.Duration{50 * ms, 10 * ms, 30 * ms}, 30 * ms},
+		{[]time.Duration{40 * ms, 10 * ms, 20 * ms, 1000 * ms}, 30 * ms},
+	}
+	for _, tt := range tests {
+		if got := median(tt.samples); got != tt.want {
+			t.Errorf("median(%v) = %v, want %v", tt.samples, got, tt.want)
+		}
+	}
+}
+
+func TestWithLoadedLatency(t *testing.T) {
+	var conns int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	proxy := adapter.NewProxy(outbound.NewDirect())
+	res := withLoadedLatency(context.Background(), proxy, server.URL, time.Second, func() result.Result {
+		time.Sleep(3 * loadedLatencyInterval)
+		return result.Result{Name: "node"}
+	})
+	if res.Name != "node" || res.IdleLatency <= 0 || res.LoadedLatency <= 0 {
+		t.Errorf("withLoadedLatency() = %+v", res)
+	}
+	// 空闲与负载下的探测都复用预先建立的连接
+	if n := atomic.LoadInt64(&conns); n != 1 {
+		t.Errorf("probes opened %d connections, want 1", n)
+	}
+}
+
+func TestProxyConcurrentFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL + "/?bytes=%d"
+	server.Close()
+
+	res := testProxyConcurrent(context.Background(), "node", adapter.NewProxy(outbound.NewDirect()), 1024, time.Second, make([]int64, 2), url)
+	if res.Bandwidth != -1 || res.TTFB != -1 || res.FailedStreams != 2 {
+		t.Errorf("failed download = %+v; want bandwidth and ttfb -1 with 2 failed streams", res)
+	}
+}
+
+func TestSweepStopRule(t *testing.T) {
+	tests := []struct {
+		name       string
+		bandwidth  map[int]float64 // 各连接数下的带宽
+		maxStreams int
+		tried      []int
+		best       int
+		want       float64
+	}{
+		{"stops below min gain", map[int]float64{1: 100, 2: 180, 4: 190}, 16, []int{1, 2, 4}, 4, 190},
+		{"keeps best on drop", map[int]float64{1: 100, 2: 150, 4: 120}, 16, []int{1, 2, 4}, 2, 150},
+		{"capped by max streams", map[int]float64{1: 100, 2: 200, 3: 300}, 3, []int{1, 2, 3}, 3, 300},
+		{"single stream failed", map[int]float64{1: 0}, 8, []int{1}, 0, 0},
+	}
+	for _, tt := range tests {
+		var tried []int
+		res := testProxySweep(context.Background(), tt.maxStreams, func(n int) result.Result {
+			tried = append(tried, n)
+			return result.Result{Bandwidth: tt.bandwidth[n]}
+		})
+		if fmt.Sprint(tried) != fmt.Sprint(tt.tried) {
+			t.Errorf("%s: tried %v, want %v", tt.name, tried, tt.tried)
+		}
+		if res.BestStreams != tt.best || res.Bandwidth != tt.want || res.SingleStreamBandwidth != tt.bandwidth[1] {
+			t.Errorf("%s: got %+v", tt.name, res)
+		}
+	}
+
+	// 取消后立即返回当前结果
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	testProxySweep(ctx, 8, func(n int) result.Result {
+		calls++
+		cancel()
+		return result.Result{Bandwidth: 100}
+	})
+	if calls != 1 {
+		t.Errorf("sweep continued after cancel: %d calls", calls)
+	}
+}
+
+func TestSoakMonitor(t *testing.T) {
+	const threshold = 5 * time.Second
+	aborts := 0
+	m := &soakMonitor{}
+	m.session(func() { aborts++ })
+
+	t0 := time.Now()
+	m.check(t0, threshold) // 第一次连接之前不计入
+	m.connect()
+	m.lastData = t0
+
+	m.check(t0.Add(time.Second), threshold)
+	m.check(t0.Add(6*time.Second), threshold)  // 开始卡顿
+	m.check(t0.Add(10*time.Second), threshold) // 同一次卡顿
+	if m.stalls != 1 || aborts != 0 {
+		t.Fatalf("stalls %d aborts %d after one stall", m.stalls, aborts)
+	}
+	m.check(t0.Add(15*time.Second), threshold) // 卡顿达到 3 倍阈值，断开重连
+	m.check(t0.Add(16*time.Second), threshold)
+	if aborts != 1 {
+		t.Errorf("aborted %d times, want 1", aborts)
+	}
+	if connected, aborted := m.end(); !connected || !aborted {
+		t.Errorf("end() = %v %v, want true true", connected, aborted)
+	}
+
+	// 断开期间不算卡顿，但计入总时间
+	m.check(t0.Add(17*time.Second), threshold)
+	m.session(func() { aborts++ })
+	m.connect()
+	m.lastData = t0.Add(18 * time.Second)
+	m.check(t0.Add(19*time.Second), threshold)
+	m.check(t0.Add(24*time.Second), threshold) // 新连接上的第二次卡顿
+	if m.stalls != 2 || m.up != 2 || m.total != 8 {
+		t.Errorf("stalls %d up %d total %d, want 2 2 8", m.stalls, m.up, m.total)
+	}
+}
+
+func TestIsSharedBandwidth(t *testing.T) {
+	tests := []struct {
+		name string
+		pair result.PairResult
+		want bool
+	}{
+		{"independent", result.PairResult{AloneA: 100, AloneB: 100, TogetherA: 95, TogetherB: 90}, false},
+		{"shared", result.PairResult{AloneA: 100, AloneB: 100, TogetherA: 55, TogetherB: 50}, true},
+		// 阈值为两种假设的中点：(200 + 100) / 2
+		{"just below midpoint", result.PairResult{AloneA: 100, AloneB: 100, TogetherA: 75, TogetherB: 74}, true},
+		{"at midpoint", result.PairResult{AloneA: 100, AloneB: 100, TogetherA: 75, TogetherB: 75}, false},
+		{"uneven nodes", result.PairResult{AloneA: 100, AloneB: 20, TogetherA: 90, TogetherB: 5}, true},
+		{"one failed together", result.PairResult{AloneA: 100, AloneB: 100, TogetherA: 50, TogetherB: 0}, false},
+	}
+	for _, tt := range tests {
+		if got := isSharedBandwidth(tt.pair); got != tt.want {
+			t.Errorf("%s: isSharedBandwidth() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if pairKey("b", "a") != pairKey("a", "b") {
+		t.Error("pairKey depends on order")
+	}
+
+	// 与单独测速的大小相同，并受剩余流量限制
+	used := int64(70 << 20)
+	opts := Options{SizeMB: 100, Traffic: &used}
+	a, b := &result.Result{TestSize: 40 << 20}, &result.Result{}
+	if sizes := pairSizes(a, b, opts); sizes != [2]int64{40 << 20, 100 << 20} {
+		t.Errorf("pairSizes = %v; want the sizes of the single tests", sizes)
+	}
+	opts.MaxTraffic = 130 << 20
+	if sizes := pairSizes(a, b, opts); sizes != [2]int64{30 << 20, 30 << 20} {
+		t.Errorf("pairSizes = %v; want half of the remaining 60MB each", sizes)
+	}
+}
@@ -0,0 +1,105 @@
+package tester
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+
+	"github.com/metacubex/mihomo/adapter"
+	cutils "github.com/metacubex/mihomo/common/utils"
+	"github.com/metacubex/mihomo/component/dialer"
+	C "github.com/metacubex/mihomo/constant"
+)
+
+// meteredProxy 统计经过代理的 TCP 与 UDP 流量（上传与下载），每个字节累加到所有 counters
+type meteredProxy struct {
+	C.Proxy
+	counters []*int64
+	// urlTester 以 meteredProxy 为出站，使 mihomo 的 URLTest 通过统计流量的连接进行
+	urlTester C.Proxy
+}
+
+// meter 返回统计流量的代理，所有测试都应通过它建立连接
+func meter(proxy C.Proxy, counters ...*int64) C.Proxy {
+	p := &meteredProxy{Proxy: proxy, counters: counters}
+	p.urlTester = adapter.NewProxy(p)
+	return p
+}
+
+func (p *meteredProxy) add(n int) {
+	if n <= 0 {
+		return
+	}
+	for _, counter := range p.counters {
+		atomic.AddInt64(counter, int64(n))
+	}
+}
+
+func (p *meteredProxy) DialContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.Conn, error) {
+	conn, err := p.Proxy.DialContext(ctx, metadata, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredConn{Conn: conn, proxy: p}, nil
+}
+
+func (p *meteredProxy) ListenPacketContext(ctx context.Context, metadata *C.Metadata, opts ...dialer.Option) (C.PacketConn, error) {
+	pc, err := p.Proxy.ListenPacketContext(ctx, metadata, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &meteredPacketConn{PacketConn: pc, proxy: p}, nil
+}
+
+// URLTest 使用 mihomo 的 URLTest 测试延迟，连接经过 meteredProxy 统计流量
+func (p *meteredProxy) URLTest(ctx context.Context, url string, expectedStatus cutils.IntRanges[uint16]) (uint16, error) {
+	return p.urlTester.URLTest(ctx, url, expectedStatus)
+}
+
+type meteredConn struct {
+	C.Conn
+	proxy *meteredProxy
+}
+
+func (c *meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.proxy.add(n)
+	return n, err
+}
+
+func (c *meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.proxy.add(n)
+	return n, err
+}
+
+type meteredPacketConn struct {
+	C.PacketConn
+	proxy *meteredProxy
+}
+
+func (c *meteredPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	c.proxy.add(n)
+	return n, addr, err
+}
+
+func (c *meteredPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	c.proxy.add(n)
+	return n, err
+}
+
+// overBudget 判断整次测试的流量是否已达到上限
+func overBudget(opts Options, total *int64) bool {
+	return opts.MaxTraffic > 0 && atomic.LoadInt64(total) >= opts.MaxTraffic
+}
+
+// remainingTraffic 返回距整次测试流量上限剩余的字节数，没有上限时 ok 为 false。
+// opts 需已由 withTraffic 设置流量计数
+func remainingTraffic(opts Options) (remaining int64, ok bool) {
+	if opts.MaxTraffic <= 0 {
+		return 0, false
+	}
+	return opts.MaxTraffic - atomic.LoadInt64(opts.Traffic), true
+}
@@ -0,0 +1,208 @@
+package tester
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/0x10240/mihomo-speedtest/result"
+	"github.com/metacubex/mihomo/component/resolver"
+	C "github.com/metacubex/mihomo/constant"
+	"github.com/miekg/dns"
+)
+
+const (
+	UDPModeDNS  = "dns"
+	UDPModeEcho = "echo"
+)
+
+// UDPOptions 描述通过代理的 UDP 连通性测试
+type UDPOptions struct {
+	// Target 为 host:port 形式的 UDP 目标地址
+	Target string
+	// Mode 为 dns（发送 DNS 查询）或 echo（发送数据报并等待原样返回）
+	Mode string
+	// Count 为发送的探测包数量
+	Count int
+	// Domain 为 dns 模式下查询的域名
+	Domain string
+}
+
+type udpStats struct {
+	sent     int
+	received int
+	totalRTT time.Duration
+}
+
+func (s udpStats) rtt() time.Duration {
+	if s.received == 0 {
+		return 0
+	}
+	return s.totalRTT / time.Duration(s.received)
+}
+
+func (s udpStats) loss() float64 {
+	if s.sent == 0 {
+		return 1
+	}
+	return float64(s.sent-s.received) / float64(s.sent)
+}
+
+// setProxyUDPResult 通过代理的 ListenPacketContext 测试 UDP 转发，并写入 res
+func setProxyUDPResult(ctx context.Context, proxy C.Proxy, res *result.Result, opts UDPOptions, timeout time.Duration) {
+	stats, err := testProxyUDP(ctx, proxy, opts, timeout)
+	res.UDPRTT = stats.rtt()
+	res.UDPLoss = stats.loss()
+
+	switch {
+	case err == nil && stats.received > 0:
+		res.UDPStatus = result.UDPStatusOK
+	case proxy.SupportUDP():
+		// 配置声明了 udp: true 却无法转发
+		res.UDPStatus = result.UDPStatusBroken
+	default:
+		res.UDPStatus = result.UDPStatusUnsupported
+	}
+}
+
+func testProxyUDP(ctx context.Context, proxy C.Proxy, opts UDPOptions, timeout time.Duration) (udpStats, error) {
+	stats := udpStats{}
+	if opts.Count <= 0 {
+		opts.Count = 1
+	}
+
+	metadata, err := udpMetadata(opts.Target)
+	if err != nil {
+		return stats, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	pc, err := proxy.ListenPacketContext(ctx, metadata)
+	if err != nil {
+		return stats, err
+	}
+	defer pc.Close()
+
+	// 目标为域名时交给代理解析，直连等需要 IP 的出站会在 ListenPacketContext 中自行解析
+	var addr net.Addr = hostAddr(opts.Target)
+	if metadata.Resolved() {
+		addr = metadata.UDPAddr()
+	}
+
+	// 每个探测包的等待时间，整体不超过 timeout
+	probeTimeout := timeout / time.Duration(opts.Count)
+
+	buf := make([]byte, 2048)
+	for seq := 0; seq < opts.Count; seq++ {
+		if ctx.Err() != nil {
+			// 已发出探测包时按已发出的包计算丢包率，不视为转发失败
+			if stats.sent > 0 {
+				return stats, nil
+			}
+			return stats, ctx.Err()
+		}
+		payload, match, err := buildUDPProbe(opts, uint16(seq))
+		if err != nil {
+			return stats, err
+		}
+
+		start := time.Now()
+		_, err = pc.WriteTo(payload, addr)
+		if _, isHost := addr.(hostAddr); err != nil && isHost {
+			// 部分协议只接受 IP 地址，此时与 mihomo 处理 UDP 一样在本地解析
+			var ip netip.Addr
+			if ip, err = resolver.ResolveIP(ctx, metadata.Host); err == nil {
+				metadata.DstIP = ip
+				addr = metadata.UDPAddr()
+				_, err = pc.WriteTo(payload, addr)
+			}
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.sent++
+
+		deadline := start.Add(probeTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		pc.SetReadDeadline(deadline)
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			// 丢弃迟到的旧响应
+			if match(buf[:n]) {
+				stats.received++
+				stats.totalRTT += time.Since(start)
+				break
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// hostAddr 为域名形式的 UDP 地址，代理协议将域名原样发给服务端解析
+type hostAddr string
+
+func (a hostAddr) Network() string { return "udp" }
+func (a hostAddr) String() string  { return string(a) }
+
+// udpMetadata 将 host:port 形式的目标转换为 Metadata，域名保留在 Host 中不在本地解析
+func udpMetadata(target string) (*C.Metadata, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %s", target)
+	}
+	metadata := &C.Metadata{NetWork: C.UDP, DstPort: uint16(p)}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		metadata.DstIP = ip.Unmap()
+	} else {
+		metadata.Host = host
+	}
+	return metadata, nil
+}
+
+// buildUDPProbe 返回探测包以及判断响应是否对应该探测包的函数
+func buildUDPProbe(opts UDPOptions, seq uint16) ([]byte, func([]byte) bool, error) {
+	switch opts.Mode {
+	case UDPModeDNS, "":
+		domain := opts.Domain
+		if domain == "" {
+			domain = "www.gstatic.com"
+		}
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(domain), dns.TypeA)
+		msg.Id = seq + 1
+		payload, err := msg.Pack()
+		if err != nil {
+			return nil, nil, err
+		}
+		return payload, func(b []byte) bool {
+			resp := new(dns.Msg)
+			return resp.Unpack(b) == nil && resp.Id == msg.Id && resp.Response
+		}, nil
+	case UDPModeEcho:
+		payload := make([]byte, 32)
+		copy(payload, "mihomo-speedtest")
+		binary.BigEndian.PutUint16(payload[30:], seq)
+		return payload, func(b []byte) bool {
+			return bytes.Equal(b, payload)
+		}, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported udp mode: %s", opts.Mode)
+	}
+}